@@ -19,21 +19,23 @@ package main
 import (
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"strconv"
 	"time"
 
 	"github.com/golang/glog"
-	kubeinformers "k8s.io/client-go/informers"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/tools/clientcmd"
 	// required to run with tectonic auth
 	_ "k8s.io/client-go/plugin/pkg/client/auth/oidc"
 
+	"github.com/solarwinds/appoptics-kubernetes-controller/pkg/cli"
 	clientset "github.com/solarwinds/appoptics-kubernetes-controller/pkg/client/clientset/versioned"
 	aoscheme "github.com/solarwinds/appoptics-kubernetes-controller/pkg/client/clientset/versioned/scheme"
-	informers "github.com/solarwinds/appoptics-kubernetes-controller/pkg/client/informers/externalversions"
+	"github.com/solarwinds/appoptics-kubernetes-controller/pkg/config"
 	"github.com/solarwinds/appoptics-kubernetes-controller/pkg/controller"
 	"github.com/solarwinds/appoptics-kubernetes-controller/pkg/signals"
 )
@@ -41,18 +43,49 @@ import (
 var (
 	masterURL  string
 	kubeconfig string
+
+	configFlags = config.RegisterFlags(flag.CommandLine)
 )
 
 const namespaceEnvVar = "NAMESPACE"
 const resyncEnvVar = "RESYNC_SECS"
 const controllerAgentName = "appoptics"
 
+// configReloadInterval is how often the configuration file is checked for changes
+const configReloadInterval = 10 * time.Second
+
 func main() {
+	// validate and diff work on manifest files alone and never talk to Kubernetes
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate":
+			os.Exit(cli.RunValidate(os.Args[2:], os.Stdout))
+		case "diff":
+			os.Exit(cli.RunDiff(os.Args[2:], os.Stdout))
+		}
+	}
+
 	flag.Parse()
 
 	// set up signals so we handle the first shutdown signal gracefully
 	stopCh := signals.SetupSignalHandler()
 
+	controllerConfig, err := loadConfig()
+	if err != nil {
+		glog.Fatalf("Error loading the configuration: %s", err.Error())
+	}
+	if problems := controllerConfig.Validate(); len(problems) > 0 {
+		for _, problem := range problems {
+			glog.Errorf("Invalid configuration: %v", problem)
+		}
+		glog.Fatalf("Invalid configuration, %d problems", len(problems))
+	}
+
+	scope, err := getNamespaceScope(controllerConfig)
+	if err != nil {
+		glog.Fatalf("Error getting the namespaces to watch: %s", err.Error())
+	}
+
 	cfg, err := clientcmd.BuildConfigFromFlags(masterURL, kubeconfig)
 	if err != nil {
 		glog.Fatalf("Error building kubeconfig: %s", err.Error())
@@ -68,28 +101,30 @@ func main() {
 		glog.Fatalf("Error building ao clientset: %s", err.Error())
 	}
 
-	resyncInSecs, err := getResync()
-	if err != nil {
-		glog.Fatalf("Error getting ao resync time: %s", err.Error())
-	}
-
-	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeClient, time.Second*time.Duration(resyncInSecs))
-
-	aoInformerFactory, err := getAppOpticsInformerFactory(aoClient, time.Second*time.Duration(resyncInSecs))
-	if err != nil {
-		glog.Fatalf("Error getting ao namespace: %s", err.Error())
-	}
 	customScheme := scheme.Scheme
 	aoscheme.AddToScheme(customScheme)
 
-	controller := controller.NewController(kubeClient, aoClient, aoInformerFactory, controllerAgentName, resyncInSecs)
+	controller := controller.NewController(kubeClient, aoClient, controllerAgentName, controllerConfig, scope)
+
+	if configFlags.File != "" {
+		go config.Watch(configFlags.File, configReloadInterval, stopCh, func(reloaded config.Config) {
+			// Flags and environment variables keep overriding the file
+			if err := applyOverrides(&reloaded); err != nil {
+				glog.Warningf("Ignoring reloaded configuration: %s", err.Error())
+				return
+			}
+			controller.Reload(reloaded)
+		})
+	}
 
-	go kubeInformerFactory.Start(stopCh)
-	go aoInformerFactory.Start(stopCh)
+	if controllerConfig.MetricsAddress != "" {
+		// The expvar package serves the controller's metrics at /debug/vars
+		go func() {
+			glog.Fatal(http.ListenAndServe(controllerConfig.MetricsAddress, nil))
+		}()
+	}
 
-	// If we want more workers... just change the first param from 1 to
-	// the number of workers we want!
-	if err = controller.Run(1, stopCh); err != nil {
+	if err = controller.Run(controllerConfig.Workers, stopCh); err != nil {
 		glog.Fatalf("Error running controller: %s", err.Error())
 	}
 }
@@ -99,6 +134,29 @@ func init() {
 	flag.StringVar(&masterURL, "master", "", "The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
 }
 
+// loadConfig reads the configuration file over the defaults, then applies the environment variables
+// and the flags that are set
+func loadConfig() (config.Config, error) {
+	cfg, err := config.Load(configFlags.File)
+	if err != nil {
+		return cfg, err
+	}
+	return cfg, applyOverrides(&cfg)
+}
+
+// applyOverrides applies the RESYNC_SECS environment variable and the flags that are set
+func applyOverrides(cfg *config.Config) error {
+	if _, found := os.LookupEnv(resyncEnvVar); found {
+		resyncInSecs, err := getResync()
+		if err != nil {
+			return err
+		}
+		cfg.Resync = metav1.Duration{Duration: time.Duration(resyncInSecs) * time.Second}
+	}
+	configFlags.Apply(cfg)
+	return nil
+}
+
 func getNamespace() (string, error) {
 	return getEnvVar(namespaceEnvVar)
 }
@@ -126,16 +184,21 @@ func getEnvVar(name string) (string, error) {
 	return v, nil
 }
 
-func getAppOpticsInformerFactory(client *clientset.Clientset, resyncSeconds time.Duration) (informers.SharedInformerFactory, error) {
-	_, namespaced := os.LookupEnv(namespaceEnvVar)
-	if namespaced {
+// getNamespaceScope combines the namespaces of the configuration and the NAMESPACE environment
+// variable with its namespace selector
+func getNamespaceScope(cfg config.Config) (controller.NamespaceScope, error) {
+	scope := controller.NamespaceScope{Names: cfg.Namespaces}
+	if _, namespaced := os.LookupEnv(namespaceEnvVar); namespaced {
 		aoNamespace, err := getNamespace()
 		if err != nil {
-			return nil, err
+			return scope, err
 		}
-		return informers.NewFilteredSharedInformerFactory(client, resyncSeconds, aoNamespace, nil), nil
+		scope.Names = append(scope.Names, aoNamespace)
 	}
-
-	return informers.NewSharedInformerFactory(client, resyncSeconds), nil
-
+	selector, err := cfg.Selector()
+	if err != nil {
+		return scope, fmt.Errorf("invalid namespace selector %q: %v", cfg.NamespaceSelector, err)
+	}
+	scope.Selector = selector
+	return scope, nil
 }
@@ -0,0 +1,68 @@
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// GetCondition returns the condition of the given type, or nil if it is not set
+func (s *Status) GetCondition(conditionType ConditionType) *Condition {
+	for i := range s.Conditions {
+		if s.Conditions[i].Type == conditionType {
+			return &s.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// SetCondition adds or updates the condition of the given type. The transition time only
+// moves when the condition status actually changes.
+func (s *Status) SetCondition(conditionType ConditionType, status ConditionStatus, reason, message string) {
+	condition := s.GetCondition(conditionType)
+	if condition == nil {
+		s.Conditions = append(s.Conditions, Condition{Type: conditionType})
+		condition = &s.Conditions[len(s.Conditions)-1]
+	}
+	if condition.Status != status {
+		condition.LastTransitionTime = metav1.Now()
+	}
+	condition.Status = status
+	condition.Reason = reason
+	condition.Message = message
+}
+
+// RemoveCondition drops the condition of the given type if it is set
+func (s *Status) RemoveCondition(conditionType ConditionType) {
+	conditions := s.Conditions[:0]
+	for _, condition := range s.Conditions {
+		if condition.Type != conditionType {
+			conditions = append(conditions, condition)
+		}
+	}
+	s.Conditions = conditions
+}
+
+// IsConditionTrue reports whether the condition of the given type is set and True
+func (s *Status) IsConditionTrue(conditionType ConditionType) bool {
+	condition := s.GetCondition(conditionType)
+	return condition != nil && condition.Status == ConditionTrue
+}
+
+// GetCondition returns the condition of the given type, or nil if it is not set
+func (s *MaintenanceWindowStatus) GetCondition(conditionType ConditionType) *Condition {
+	status := Status{Conditions: s.Conditions}
+	return status.GetCondition(conditionType)
+}
+
+// SetCondition adds or updates the condition of the given type, like Status.SetCondition
+func (s *MaintenanceWindowStatus) SetCondition(conditionType ConditionType, status ConditionStatus, reason, message string) {
+	conditions := Status{Conditions: s.Conditions}
+	conditions.SetCondition(conditionType, status, reason, message)
+	s.Conditions = conditions.Conditions
+}
+
+// RemoveCondition drops the condition of the given type if it is set
+func (s *MaintenanceWindowStatus) RemoveCondition(conditionType ConditionType) {
+	status := Status{Conditions: s.Conditions}
+	status.RemoveCondition(conditionType)
+	s.Conditions = status.Conditions
+}
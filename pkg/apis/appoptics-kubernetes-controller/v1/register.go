@@ -34,6 +34,14 @@ func addKnownTypes(scheme *runtime.Scheme) error {
 		&AppOpticsServiceList{},
 		&AppOpticsAlert{},
 		&AppOpticsAlertList{},
+		&AppOpticsMetric{},
+		&AppOpticsMetricList{},
+		&AppOpticsCompositeMetric{},
+		&AppOpticsCompositeMetricList{},
+		&AppOpticsMaintenanceWindow{},
+		&AppOpticsMaintenanceWindowList{},
+		&AppOpticsAlertPolicy{},
+		&AppOpticsAlertPolicyList{},
 	)
 	metav1.AddToGroupVersion(scheme, SchemeGroupVersion)
 	return nil
@@ -34,22 +34,151 @@ type AppOpticsAlert struct {
 	Status            Status           `json:"status,omitempty"`
 }
 
+// +genclient
+// +genclient:noStatus
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type AppOpticsMetric struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              TokenAndDataSpec `json:"spec"`
+	Status            Status           `json:"status,omitempty"`
+}
+
+// +genclient
+// +genclient:noStatus
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type AppOpticsCompositeMetric struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              TokenAndDataSpec `json:"spec"`
+	Status            Status           `json:"status,omitempty"`
+}
+
+// +genclient
+// +genclient:noStatus
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type AppOpticsMaintenanceWindow struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              MaintenanceWindowSpec   `json:"spec"`
+	Status            MaintenanceWindowStatus `json:"status,omitempty"`
+}
+
+// MaintenanceWindowSpec mutes the selected alerts between Start and End, and when Schedule is set
+// only for Duration every time the schedule fires
+type MaintenanceWindowSpec struct {
+	// Selector picks the AppOpticsAlerts in the window's namespace, an empty selector picks all of them
+	Selector metav1.LabelSelector `json:"selector"`
+	Start    *metav1.Time         `json:"start,omitempty"`
+	End      *metav1.Time         `json:"end,omitempty"`
+	// Schedule is a cron expression, in UTC
+	Schedule string          `json:"schedule,omitempty"`
+	Duration metav1.Duration `json:"duration,omitempty"`
+}
+
+type MaintenanceWindowStatus struct {
+	Open               bool        `json:"open"`
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+	// Conditions holds the SpecValid condition while the window's spec is invalid
+	Conditions []Condition `json:"conditions,omitempty"`
+}
+
+// +genclient
+// +genclient:noStatus
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type AppOpticsAlertPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              AlertPolicySpec `json:"spec"`
+}
+
+// AlertPolicySpec configures the baseline alerts generated for the workloads naming the policy. A
+// threshold left out gets its default, a threshold of 0 turns that alert off.
+type AlertPolicySpec struct {
+	// Restarts is how many container restarts of a pod between two measurements fire the restarts alert
+	Restarts *int `json:"restarts,omitempty"`
+	// CPUThrottlingPercent is the share of CPU periods throttled that fires the CPU throttling alert
+	CPUThrottlingPercent *int `json:"cpuThrottlingPercent,omitempty"`
+	// MemoryPercent is the share of the memory limit used that fires the memory alert
+	MemoryPercent *int `json:"memoryPercent,omitempty"`
+	// Secret holds the AppOptics token of the generated alerts, the workload's appoptics.io/secret
+	// annotation when it is empty
+	Secret string `json:"secret,omitempty"`
+	// ServiceRefs lists the AppOpticsServices the generated alerts notify
+	ServiceRefs []ObjectReference `json:"serviceRefs,omitempty"`
+}
+
 type TokenAndDataSpec struct {
 	Namespace string `json:"namespace"`
 	Data      string `json:"data"`
-	Secret    string `json:"secret"`
+	// Secret holds the AppOptics token, the controller's default secret when it is empty
+	Secret string `json:"secret"`
+	// ServiceRefs lists the AppOpticsServices an alert notifies, other kinds ignore it
+	ServiceRefs []ObjectReference `json:"serviceRefs,omitempty"`
+}
+
+// ObjectReference points at another resource of the controller, in the referencing resource's
+// namespace when Namespace is empty
+type ObjectReference struct {
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
 }
 
 type Status struct {
-	LastUpdated string `json:"lastUpdated,omitempty"`
-	ID          int    `json:"id,omitempty"`
-	Hashes      Hashes `json:"Hashes,omitempty"`
-	UpdatedAt   int    `json:"updatedAt,omitempty"`
+	LastUpdated        string      `json:"lastUpdated,omitempty"`
+	ID                 int         `json:"id,omitempty"`
+	Name               string      `json:"name,omitempty"`
+	Hashes             Hashes      `json:"Hashes,omitempty"`
+	UpdatedAt          int         `json:"updatedAt,omitempty"`
+	ObservedGeneration int64       `json:"observedGeneration,omitempty"`
+	Conditions         []Condition `json:"conditions,omitempty"`
+	PlannedChanges     []string    `json:"plannedChanges,omitempty"`
+	// MaintenanceWindows lists the open AppOpticsMaintenanceWindows an alert is muted by
+	MaintenanceWindows []string `json:"maintenanceWindows,omitempty"`
+	// Firing and Active mirror the state of an alert in AppOptics
+	Firing *bool `json:"firing,omitempty"`
+	Active *bool `json:"active,omitempty"`
+	// FiringObservedAt is when the controller last saw the alert start firing, up to one poll after
+	// AppOptics fired it, which the AppOptics API does not report
+	FiringObservedAt *metav1.Time `json:"firingObservedAt,omitempty"`
+}
+
+type ConditionType string
+
+type ConditionStatus string
+
+const (
+	// ConditionSynced reports whether the resource was last synced to AppOptics successfully
+	ConditionSynced ConditionType = "Synced"
+	// ConditionServicesReady reports whether every service an alert references exists and is synced
+	ConditionServicesReady ConditionType = "ServicesReady"
+	// ConditionPaused is set while reconciliation of the resource is paused
+	ConditionPaused ConditionType = "Paused"
+	// ConditionMetricsFound reports whether every metric a dashboard or alert uses exists in AppOptics
+	ConditionMetricsFound ConditionType = "MetricsFound"
+	// ConditionSettingsValid reports whether a service has the settings its type requires
+	ConditionSettingsValid ConditionType = "SettingsValid"
+	// ConditionSpecValid reports whether a maintenance window's schedule can be evaluated
+	ConditionSpecValid ConditionType = "SpecValid"
+
+	ConditionTrue    ConditionStatus = "True"
+	ConditionFalse   ConditionStatus = "False"
+	ConditionUnknown ConditionStatus = "Unknown"
+)
+
+type Condition struct {
+	Type               ConditionType   `json:"type"`
+	Status             ConditionStatus `json:"status"`
+	Reason             string          `json:"reason,omitempty"`
+	Message            string          `json:"message,omitempty"`
+	LastTransitionTime metav1.Time     `json:"lastTransitionTime,omitempty"`
 }
 
 type Hashes struct {
 	Spec      []byte `json:"spec,omitempty"`
 	AppOptics []byte `json:"appoptics,omitempty"`
+	// InvalidSpec is the hash of the spec that last failed permanently, which is not retried
+	InvalidSpec []byte `json:"invalidSpec,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -72,3 +201,31 @@ type AppOpticsAlertList struct {
 	metav1.ListMeta `json:"metadata"`
 	Items           []AppOpticsAlert `json:"items"`
 }
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type AppOpticsMetricList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+	Items           []AppOpticsMetric `json:"items"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type AppOpticsCompositeMetricList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+	Items           []AppOpticsCompositeMetric `json:"items"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type AppOpticsMaintenanceWindowList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+	Items           []AppOpticsMaintenanceWindow `json:"items"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type AppOpticsAlertPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+	Items           []AppOpticsAlertPolicy `json:"items"`
+}
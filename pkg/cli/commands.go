@@ -0,0 +1,81 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	aoApi "github.com/appoptics/appoptics-api-go"
+)
+
+const tokenEnvVar = "APPOPTICS_TOKEN"
+
+// RunValidate implements the validate subcommand and returns the process exit code
+func RunValidate(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	flags.SetOutput(out)
+	flags.Usage = func() {
+		fmt.Fprintln(out, "Usage: appoptics-kubernetes-controller validate FILE|DIR...")
+		fmt.Fprintln(out, "Checks AppOptics resources in manifests without connecting to Kubernetes or AppOptics.")
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	manifests, err := ReadManifests(flags.Args())
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+	if problems := Validate(manifests, out); problems > 0 {
+		fmt.Fprintf(out, "%d problems found in %d resources\n", problems, len(manifests))
+		return 1
+	}
+	fmt.Fprintf(out, "%d resources are valid\n", len(manifests))
+	return 0
+}
+
+// RunDiff implements the diff subcommand and returns the process exit code
+func RunDiff(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("diff", flag.ContinueOnError)
+	flags.SetOutput(out)
+	token := flags.String("token", os.Getenv(tokenEnvVar), "AppOptics API token, defaults to the "+tokenEnvVar+" environment variable.")
+	flags.Usage = func() {
+		fmt.Fprintln(out, "Usage: appoptics-kubernetes-controller diff [-token TOKEN] FILE|DIR...")
+		fmt.Fprintln(out, "Shows how AppOptics resources in manifests differ from the AppOptics account, without connecting to Kubernetes.")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 || *token == "" {
+		flags.Usage()
+		return 2
+	}
+
+	manifests, err := ReadManifests(flags.Args())
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+	if problems := Validate(manifests, out); problems > 0 {
+		fmt.Fprintf(out, "%d problems found in %d resources\n", problems, len(manifests))
+		return 1
+	}
+	changed, err := Diff(manifests, aoApi.NewClient(*token), out)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+	if changed > 0 {
+		fmt.Fprintf(out, "%d of %d resources differ from AppOptics\n", changed, len(manifests))
+		return 1
+	}
+	fmt.Fprintf(out, "%d resources match AppOptics\n", len(manifests))
+	return 0
+}
@@ -0,0 +1,255 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	aoApi "github.com/appoptics/appoptics-api-go"
+	"github.com/solarwinds/appoptics-kubernetes-controller/pkg/controller/appoptics"
+)
+
+// Diff compares every manifest with the matching resource in the AppOptics account, found by
+// name, and writes what the controller would change. Only the fields set in the manifest are
+// compared, so values AppOptics fills in itself (ids, timestamps) never show up as changes.
+// It returns the number of resources that differ.
+func Diff(manifests []Manifest, client *aoApi.Client, out io.Writer) (int, error) {
+	changed := 0
+	for _, manifest := range manifests {
+		desired, remote, err := desiredAndRemote(manifest, manifests, client)
+		if err != nil {
+			return changed, fmt.Errorf("%s: %v", manifest, err)
+		}
+		if remote == nil {
+			fmt.Fprintf(out, "%s: would be created\n", manifest)
+			changed++
+			continue
+		}
+
+		desiredFields, err := toFields(desired)
+		if err != nil {
+			return changed, err
+		}
+		remoteFields, err := toFields(remote)
+		if err != nil {
+			return changed, err
+		}
+		remoteFields = project(remoteFields, desiredFields)
+		if reflect.DeepEqual(desiredFields, remoteFields) {
+			continue
+		}
+
+		fmt.Fprintf(out, "%s: would be updated\n", manifest)
+		desiredLines, err := indentedLines(desiredFields)
+		if err != nil {
+			return changed, err
+		}
+		remoteLines, err := indentedLines(remoteFields)
+		if err != nil {
+			return changed, err
+		}
+		writeLineDiff(out, remoteLines, desiredLines)
+		changed++
+	}
+	return changed, nil
+}
+
+// desiredAndRemote decodes the manifest and looks up its counterpart in AppOptics. remote is nil
+// when AppOptics has no resource with that name.
+func desiredAndRemote(manifest Manifest, manifests []Manifest, client *aoApi.Client) (desired interface{}, remote interface{}, err error) {
+	data := manifest.Spec.Data
+	if manifest.Kind == DashboardKind || manifest.Kind == AlertKind {
+		data, err = appoptics.ResolveCompositeMetrics(strings.TrimPrefix(manifest.Kind, "AppOptics"), data, compositeMetricResolver(manifest.Namespace(), manifests))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	switch manifest.Kind {
+	case DashboardKind:
+		dash, err := appoptics.ParseSpace(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		space, err := appoptics.NewSpacesService(client).FindByName(dash.Name)
+		if err != nil || space == nil {
+			return nil, nil, err
+		}
+		charts, err := appoptics.NewChartsService(client).List(space.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+		// The controller's ownership marker is not part of the manifest
+		return map[string]interface{}{"name": dash.Name, "charts": dash.Charts},
+			map[string]interface{}{"name": appoptics.UnmarkedName(space.Name), "charts": charts}, nil
+	case ServiceKind:
+		refs, err := appoptics.SecretKeyRefs(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		data, err = appoptics.ResolveSecretKeyRefs(data, appoptics.RedactSecretKeyRef)
+		if err != nil {
+			return nil, nil, err
+		}
+		service, err := appoptics.ParseService(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		if service.Title == nil {
+			return nil, nil, fmt.Errorf("title is required")
+		}
+		aoService, err := appoptics.NewServicesService(client).FindByTitle(*service.Title)
+		if err != nil || aoService == nil {
+			return nil, nil, err
+		}
+		remoteFields, err := toFields(aoService)
+		if err != nil {
+			return nil, nil, err
+		}
+		fields := remoteFields.(map[string]interface{})
+		// The controller's ownership marker is not part of the manifest
+		if title, ok := fields["title"].(string); ok {
+			fields["title"] = appoptics.UnmarkedName(title)
+		}
+		// Settings read from Secrets are never printed, and their values are not compared
+		if settings, ok := fields["settings"].(map[string]interface{}); ok {
+			for setting, ref := range refs {
+				if _, found := settings[setting]; found {
+					settings[setting], _ = appoptics.RedactSecretKeyRef(ref.Name, ref.Key)
+				}
+			}
+		}
+		return service, remoteFields, nil
+	case MetricKind, CompositeMetricKind:
+		parse := appoptics.ParseMetric
+		if manifest.Kind == CompositeMetricKind {
+			parse = appoptics.ParseCompositeMetric
+		}
+		metric, err := parse(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		aoMetric, err := appoptics.NewMetricsService(client).Retrieve(metric.Name)
+		if err != nil {
+			if appoptics.CheckIfErrorIsAppOpticsNotFoundError(err, appoptics.Metric, 0) {
+				return nil, nil, nil
+			}
+			return nil, nil, err
+		}
+		return metric, aoMetric, nil
+	case AlertKind:
+		alert, err := appoptics.ParseAlert(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		if alert.Name == nil {
+			return nil, nil, fmt.Errorf("name is required")
+		}
+		aoAlert, err := appoptics.NewAlertsService(client, nil, "").FindByName(*alert.Name)
+		if err != nil || aoAlert == nil {
+			return nil, nil, err
+		}
+		// Services are associated from serviceRefs and attributes.services by the controller, compared there
+		alert.Services = nil
+		aoAlert.Services = nil
+		return alert, aoAlert, nil
+	}
+	return nil, nil, fmt.Errorf("unknown kind %s", manifest.Kind)
+}
+
+// toFields turns v into the generic form encoding/json produces, so values can be compared
+// field by field
+func toFields(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var fields interface{}
+	err = json.Unmarshal(b, &fields)
+	return fields, err
+}
+
+// project drops everything from remote that is not also set in desired
+func project(remote, desired interface{}) interface{} {
+	switch desiredValue := desired.(type) {
+	case map[string]interface{}:
+		remoteMap, ok := remote.(map[string]interface{})
+		if !ok {
+			return remote
+		}
+		projected := map[string]interface{}{}
+		for key, value := range desiredValue {
+			if remoteValue, ok := remoteMap[key]; ok {
+				projected[key] = project(remoteValue, value)
+			}
+		}
+		return projected
+	case []interface{}:
+		remoteList, ok := remote.([]interface{})
+		if !ok {
+			return remote
+		}
+		projected := make([]interface{}, len(remoteList))
+		for i, remoteValue := range remoteList {
+			if i < len(desiredValue) {
+				projected[i] = project(remoteValue, desiredValue[i])
+			} else {
+				projected[i] = remoteValue
+			}
+		}
+		return projected
+	}
+	return remote
+}
+
+func indentedLines(fields interface{}) ([]string, error) {
+	b, err := json.MarshalIndent(fields, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(string(b), "\n"), nil
+}
+
+// writeLineDiff writes the lines removed from a as "-" and the lines added in b as "+", using the
+// longest common subsequence of both
+func writeLineDiff(out io.Writer, a, b []string) {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			fmt.Fprintf(out, "  %s\n", a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			fmt.Fprintf(out, "- %s\n", a[i])
+			i++
+		default:
+			fmt.Fprintf(out, "+ %s\n", b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		fmt.Fprintf(out, "- %s\n", a[i])
+	}
+	for ; j < len(b); j++ {
+		fmt.Fprintf(out, "+ %s\n", b[j])
+	}
+}
@@ -0,0 +1,111 @@
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/solarwinds/appoptics-kubernetes-controller/pkg/apis/appoptics-kubernetes-controller/v1"
+)
+
+const (
+	DashboardKind = "AppOpticsDashboard"
+	AlertKind     = "AppOpticsAlert"
+	ServiceKind   = "AppOpticsService"
+	MetricKind    = "AppOpticsMetric"
+
+	CompositeMetricKind = "AppOpticsCompositeMetric"
+)
+
+var documentSeparator = regexp.MustCompile(`(?m)^---\s*$`)
+
+// Manifest is an AppOptics custom resource read from a file
+type Manifest struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"metadata"`
+	Spec v1.TokenAndDataSpec `json:"spec"`
+
+	// Source is the file the manifest was read from
+	Source string `json:"-"`
+}
+
+// Namespace returns the namespace the manifest would be created in
+func (m Manifest) Namespace() string {
+	if m.Metadata.Namespace == "" {
+		return "default"
+	}
+	return m.Metadata.Namespace
+}
+
+// Key identifies the manifest the same way the controller identifies resources, namespace/name
+func (m Manifest) Key() string {
+	return m.Namespace() + "/" + m.Metadata.Name
+}
+
+func (m Manifest) String() string {
+	return fmt.Sprintf("%s: %s %s", m.Source, m.Kind, m.Key())
+}
+
+// ReadManifests reads every AppOptics resource in the given files, descending into directories.
+// Documents of any other kind, like the CRDs themselves, are skipped.
+func ReadManifests(paths []string) ([]Manifest, error) {
+	var manifests []Manifest
+	for _, path := range paths {
+		err := filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			if file != path && !strings.HasSuffix(file, ".yaml") && !strings.HasSuffix(file, ".yml") {
+				return nil
+			}
+			found, err := readManifestFile(file)
+			if err != nil {
+				return err
+			}
+			manifests = append(manifests, found...)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return manifests, nil
+}
+
+func readManifestFile(file string) ([]Manifest, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var manifests []Manifest
+	for _, document := range documentSeparator.Split(string(content), -1) {
+		if strings.TrimSpace(document) == "" {
+			continue
+		}
+		var manifest Manifest
+		err = yaml.Unmarshal([]byte(document), &manifest)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		if !strings.HasPrefix(manifest.APIVersion, v1.SchemeGroupVersion.Group+"/") {
+			continue
+		}
+		switch manifest.Kind {
+		case DashboardKind, AlertKind, ServiceKind, MetricKind, CompositeMetricKind:
+			manifest.Source = file
+			manifests = append(manifests, manifest)
+		}
+	}
+	return manifests, nil
+}
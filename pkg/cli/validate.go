@@ -0,0 +1,106 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/solarwinds/appoptics-kubernetes-controller/pkg/controller/appoptics"
+)
+
+// Validate decodes the spec.data of every manifest the same way the controller does and checks
+// that alerts and dashboards only reference services and composite metrics defined in the
+// manifests. It returns the number of problems written to out.
+func Validate(manifests []Manifest, out io.Writer) int {
+	problems := 0
+	for _, manifest := range manifests {
+		for _, err := range validateManifest(manifest, manifests) {
+			fmt.Fprintf(out, "%s: %v\n", manifest, err)
+			problems++
+		}
+	}
+	return problems
+}
+
+func validateManifest(manifest Manifest, manifests []Manifest) []error {
+	var problems []error
+	data := manifest.Spec.Data
+	if manifest.Kind == DashboardKind || manifest.Kind == AlertKind {
+		var err error
+		data, err = appoptics.ResolveCompositeMetrics(strings.TrimPrefix(manifest.Kind, "AppOptics"), data, compositeMetricResolver(manifest.Namespace(), manifests))
+		if err != nil {
+			return append(problems, err)
+		}
+	}
+
+	switch manifest.Kind {
+	case DashboardKind:
+		dash, err := appoptics.ParseSpace(data)
+		if err != nil {
+			return append(problems, err)
+		}
+		problems = append(problems, appoptics.ValidateSpace(dash)...)
+	case ServiceKind:
+		// Secrets are not part of the manifests, settings read from them get a placeholder value
+		data, err := appoptics.ResolveSecretKeyRefs(data, appoptics.RedactSecretKeyRef)
+		if err != nil {
+			return append(problems, err)
+		}
+		service, err := appoptics.ParseService(data)
+		if err != nil {
+			return append(problems, err)
+		}
+		problems = append(problems, appoptics.ValidateService(service)...)
+	case MetricKind:
+		metric, err := appoptics.ParseMetric(data)
+		if err != nil {
+			return append(problems, err)
+		}
+		problems = append(problems, appoptics.ValidateMetric(metric)...)
+	case CompositeMetricKind:
+		_, err := appoptics.ParseCompositeMetric(data)
+		if err != nil {
+			return append(problems, err)
+		}
+	case AlertKind:
+		alert, err := appoptics.ParseAlert(data)
+		if err != nil {
+			return append(problems, err)
+		}
+		problems = append(problems, appoptics.ValidateAlert(alert)...)
+		refs, err := appoptics.AlertServiceRefs(manifest.Namespace(), manifest.Spec, alert)
+		if err != nil {
+			return append(problems, err)
+		}
+		for _, ref := range refs {
+			if findManifest(manifests, ServiceKind, ref.Namespace, ref.Name) == nil {
+				problems = append(problems, fmt.Errorf("references %s %s/%s which is not in the manifests", ServiceKind, ref.Namespace, ref.Name))
+			}
+		}
+	}
+	return problems
+}
+
+// compositeMetricResolver resolves composite metric references from the manifests instead of the cluster
+func compositeMetricResolver(namespace string, manifests []Manifest) appoptics.CompositeMetricResolver {
+	return func(name string) (string, error) {
+		compositeMetric := findManifest(manifests, CompositeMetricKind, namespace, name)
+		if compositeMetric == nil {
+			return "", fmt.Errorf("references %s %s/%s which is not in the manifests", CompositeMetricKind, namespace, name)
+		}
+		metric, err := appoptics.ParseCompositeMetric(compositeMetric.Spec.Data)
+		if err != nil {
+			return "", fmt.Errorf("references %s %s/%s which is invalid", CompositeMetricKind, namespace, name)
+		}
+		return metric.Name, nil
+	}
+}
+
+func findManifest(manifests []Manifest, kind, namespace, name string) *Manifest {
+	for i := range manifests {
+		if manifests[i].Kind == kind && manifests[i].Namespace() == namespace && manifests[i].Metadata.Name == name {
+			return &manifests[i]
+		}
+	}
+	return nil
+}
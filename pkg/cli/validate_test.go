@@ -0,0 +1,156 @@
+package cli
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"github.com/solarwinds/appoptics-kubernetes-controller/pkg/apis/appoptics-kubernetes-controller/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExampleManifestsAreValid(t *testing.T) {
+	manifests, err := ReadManifests([]string{"../../manifest"})
+	assert.Equal(t, nil, err)
+	read := map[string]bool{}
+	for _, manifest := range manifests {
+		read[manifest.Source] = true
+	}
+
+	// Every example of a kind the CLI checks is read
+	files, err := filepath.Glob("../../manifest/example/*.yaml")
+	assert.Equal(t, nil, err)
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		assert.Equal(t, nil, err, file)
+		var example Manifest
+		assert.Equal(t, nil, yaml.Unmarshal(content, &example), file)
+		switch example.Kind {
+		case DashboardKind, AlertKind, ServiceKind, MetricKind, CompositeMetricKind:
+			assert.True(t, read[file], file)
+		}
+	}
+
+	var out bytes.Buffer
+	assert.Equal(t, 0, Validate(manifests, &out), out.String())
+}
+
+func TestValidateReportsProblems(t *testing.T) {
+	var alert Manifest
+	alert.Kind = AlertKind
+	alert.Metadata.Name = "alert"
+	alert.Spec.Secret = "appoptics"
+	alert.Spec.Data = `
+name: "test"
+conditions:
+- type: "sideways"
+  metric_name: "cpu"
+attributes:
+  services:
+  - missing
+`
+	var dashboard Manifest
+	dashboard.Kind = DashboardKind
+	dashboard.Metadata.Name = "dashboard"
+	dashboard.Spec.Data = `name: [unclosed`
+
+	var out bytes.Buffer
+	assert.Equal(t, 4, Validate([]Manifest{alert, dashboard}, &out), out.String())
+}
+
+func TestValidateCompositeMetricReferences(t *testing.T) {
+	var compositeMetric Manifest
+	compositeMetric.Kind = CompositeMetricKind
+	compositeMetric.Metadata.Name = "cpu-total"
+	compositeMetric.Spec.Secret = "appoptics"
+	compositeMetric.Spec.Data = `
+name: cpu.total
+composite: sum(s("cpu.percent", "*"))
+`
+	var alert Manifest
+	alert.Kind = AlertKind
+	alert.Metadata.Name = "alert"
+	alert.Spec.Secret = "appoptics"
+	alert.Spec.Data = `
+name: "test"
+conditions:
+- type: "above"
+  composite_metric: cpu-total
+- type: "above"
+  composite_metric: memory-total
+`
+	var out bytes.Buffer
+	assert.Equal(t, 1, Validate([]Manifest{compositeMetric, alert}, &out), out.String())
+}
+
+func TestValidateServiceRefs(t *testing.T) {
+	var service Manifest
+	service.Kind = ServiceKind
+	service.Metadata.Name = "pagerduty"
+	service.Metadata.Namespace = "monitoring"
+	service.Spec.Secret = "appoptics"
+	service.Spec.Data = `
+type: pagerduty
+title: On call
+`
+	var alert Manifest
+	alert.Kind = AlertKind
+	alert.Metadata.Name = "alert"
+	alert.Spec.Secret = "appoptics"
+	alert.Spec.Data = `name: "test"`
+	alert.Spec.ServiceRefs = []v1.ObjectReference{
+		{Namespace: "monitoring", Name: "pagerduty"},
+		{Name: "pagerduty"},
+	}
+	var out bytes.Buffer
+	assert.Equal(t, 1, Validate([]Manifest{service, alert}, &out), out.String())
+	assert.Contains(t, out.String(), "default/pagerduty")
+}
+
+func TestValidateServiceSecretKeyRefs(t *testing.T) {
+	var service Manifest
+	service.Kind = ServiceKind
+	service.Metadata.Name = "slack"
+	service.Spec.Secret = "appoptics"
+	service.Spec.Data = `
+type: slack
+title: Slack
+settings:
+  url:
+    valueFrom:
+      secretKeyRef:
+        name: slack
+        key: webhook
+`
+	var out bytes.Buffer
+	assert.Equal(t, 0, Validate([]Manifest{service}, &out), out.String())
+
+	service.Spec.Data = `
+type: slack
+title: Slack
+settings:
+  url:
+    valueFrom:
+      secretKeyRef:
+        key: webhook
+`
+	out.Reset()
+	assert.Equal(t, 1, Validate([]Manifest{service}, &out), out.String())
+}
+
+func TestValidateServiceSettings(t *testing.T) {
+	var service Manifest
+	service.Kind = ServiceKind
+	service.Metadata.Name = "oncall"
+	service.Spec.Secret = "appoptics"
+	service.Spec.Data = `
+type: slack
+title: Oncall
+settings:
+  channel: "#oncall"
+`
+	var out bytes.Buffer
+	assert.Equal(t, 1, Validate([]Manifest{service}, &out), out.String())
+}
@@ -0,0 +1,283 @@
+// Package config reads the controller configuration from a file, such as one mounted from a
+// ConfigMap, and command line flags.
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	// APIVersion and Kind identify the version of the configuration file format
+	APIVersion = "appoptics.io/v1"
+	Kind       = "ControllerConfig"
+
+	// DeletionPolicyDelete removes the AppOptics resource when its custom resource is deleted
+	DeletionPolicyDelete = "Delete"
+	// DeletionPolicyRetain leaves the AppOptics resource as it is when its custom resource is deleted
+	DeletionPolicyRetain = "Retain"
+
+	// GarbageCollectionDelete deletes the orphaned AppOptics objects the sweep finds
+	GarbageCollectionDelete = "Delete"
+	// GarbageCollectionReport only reports the orphaned AppOptics objects the sweep finds
+	GarbageCollectionReport = "Report"
+)
+
+// Config is the configuration of the controller. DryRun, CheckMetrics, Resync, DefaultSecret,
+// DeletionPolicy, APIURL, Retry.MaxRetries, GarbageCollection.Mode and GarbageCollection.Secrets are
+// applied when the file is reloaded, changing the other fields needs a restart.
+type Config struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+
+	// ClusterID marks the AppOptics objects this controller instance owns, the UID of the kube-system
+	// namespace when it is empty
+	ClusterID string `json:"clusterID,omitempty"`
+	// Workers is how many resources are synced at the same time
+	Workers int `json:"workers"`
+	// Resync is how often a resource is synced with AppOptics when nothing changes
+	Resync metav1.Duration `json:"resync"`
+	// Namespaces and NamespaceSelector pick the namespaces to watch, all of them when neither is set
+	Namespaces        []string `json:"namespaces,omitempty"`
+	NamespaceSelector string   `json:"namespaceSelector,omitempty"`
+	// DefaultSecret holds the AppOptics token of resources that do not set spec.secret
+	DefaultSecret string `json:"defaultSecret"`
+	// DeletionPolicy is DeletionPolicyDelete or DeletionPolicyRetain
+	DeletionPolicy string `json:"deletionPolicy"`
+	// APIURL is the AppOptics API endpoint, the client's default when it is empty
+	APIURL string `json:"apiURL,omitempty"`
+	// RateLimit bounds how many resources are synced, and so how many AppOptics API calls are made
+	RateLimit RateLimit `json:"rateLimit"`
+	// Retry bounds the retries of a resource failing with a transient error
+	Retry Retry `json:"retry"`
+	// GarbageCollection sweeps AppOptics for objects this controller owns whose resource is gone
+	GarbageCollection GarbageCollection `json:"garbageCollection"`
+	// MetricsAddress serves the controller's metrics at /debug/vars, nowhere when it is empty
+	MetricsAddress string `json:"metricsAddress,omitempty"`
+
+	DryRun             bool            `json:"dryRun"`
+	CheckMetrics       bool            `json:"checkMetrics"`
+	AlertStateInterval metav1.Duration `json:"alertStateInterval"`
+}
+
+// RateLimit is a token bucket shared by every resource synced
+type RateLimit struct {
+	QPS   float64 `json:"qps"`
+	Burst int     `json:"burst"`
+}
+
+// Retry is the exponential backoff of a resource failing with a transient error. It is left to the
+// next resync after MaxRetries.
+type Retry struct {
+	MaxRetries int             `json:"maxRetries"`
+	BaseDelay  metav1.Duration `json:"baseDelay"`
+	MaxDelay   metav1.Duration `json:"maxDelay"`
+}
+
+// GarbageCollection is the periodic sweep for orphaned AppOptics objects, those marked as owned by
+// this controller instance whose custom resource no longer exists
+type GarbageCollection struct {
+	// Interval is the time between sweeps, they are off when it is 0
+	Interval metav1.Duration `json:"interval"`
+	// Mode is GarbageCollectionDelete or GarbageCollectionReport
+	Mode string `json:"mode"`
+	// Secrets lists, as namespace/name, Secrets whose accounts are swept even when no resource uses them
+	Secrets []string `json:"secrets,omitempty"`
+}
+
+// Default returns the configuration used for everything neither the file nor the flags set
+func Default() Config {
+	return Config{
+		APIVersion:         APIVersion,
+		Kind:               Kind,
+		Workers:            1,
+		Resync:             metav1.Duration{Duration: time.Minute},
+		DefaultSecret:      "appoptics",
+		DeletionPolicy:     DeletionPolicyDelete,
+		RateLimit:          RateLimit{QPS: 10, Burst: 100},
+		Retry:              Retry{MaxRetries: 10, BaseDelay: metav1.Duration{Duration: 5 * time.Second}, MaxDelay: metav1.Duration{Duration: 5 * time.Minute}},
+		GarbageCollection:  GarbageCollection{Interval: metav1.Duration{Duration: 10 * time.Minute}, Mode: GarbageCollectionReport},
+		AlertStateInterval: metav1.Duration{Duration: time.Minute},
+	}
+}
+
+// Parse reads a configuration file over the defaults
+func Parse(data []byte) (Config, error) {
+	cfg := Default()
+	if len(bytes.TrimSpace(data)) == 0 {
+		return cfg, nil
+	}
+	// The file must say which version it is, rather than get the current one by default
+	cfg.APIVersion, cfg.Kind = "", ""
+	err := yaml.Unmarshal(data, &cfg)
+	if err != nil {
+		return cfg, err
+	}
+	if cfg.APIVersion != APIVersion || cfg.Kind != Kind {
+		return cfg, fmt.Errorf("unsupported configuration %s %s, expected apiVersion %s and kind %s", cfg.APIVersion, cfg.Kind, APIVersion, Kind)
+	}
+	return cfg, nil
+}
+
+// Load reads a configuration file, the defaults when path is empty
+func Load(path string) (Config, error) {
+	if path == "" {
+		return Default(), nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	cfg, err := Parse(data)
+	if err != nil {
+		return cfg, fmt.Errorf("%s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// Validate returns every problem of the configuration
+func (c Config) Validate() []error {
+	var problems []error
+	if strings.ContainsAny(c.ClusterID, "/[]") {
+		problems = append(problems, fmt.Errorf("clusterID %q must not contain '/', '[' or ']'", c.ClusterID))
+	}
+	if c.Workers < 1 {
+		problems = append(problems, fmt.Errorf("workers must be at least 1"))
+	}
+	if c.Resync.Duration < time.Second {
+		problems = append(problems, fmt.Errorf("resync must be at least 1s"))
+	}
+	if _, err := c.Selector(); err != nil {
+		problems = append(problems, fmt.Errorf("namespaceSelector: %v", err))
+	}
+	if c.DeletionPolicy != DeletionPolicyDelete && c.DeletionPolicy != DeletionPolicyRetain {
+		problems = append(problems, fmt.Errorf("deletionPolicy %q must be %s or %s", c.DeletionPolicy, DeletionPolicyDelete, DeletionPolicyRetain))
+	}
+	if c.APIURL != "" {
+		u, err := url.Parse(c.APIURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Errorf("apiURL %q must be an absolute http or https URL", c.APIURL))
+		}
+	}
+	if c.RateLimit.QPS <= 0 || c.RateLimit.Burst < 1 {
+		problems = append(problems, fmt.Errorf("rateLimit qps must be more than 0 and burst at least 1"))
+	}
+	if c.Retry.MaxRetries < 0 {
+		problems = append(problems, fmt.Errorf("retry maxRetries must be 0 or more"))
+	}
+	if c.Retry.BaseDelay.Duration <= 0 || c.Retry.MaxDelay.Duration < c.Retry.BaseDelay.Duration {
+		problems = append(problems, fmt.Errorf("retry baseDelay must be more than 0 and no more than maxDelay"))
+	}
+	if c.GarbageCollection.Interval.Duration < 0 {
+		problems = append(problems, fmt.Errorf("garbageCollection interval must be 0 or more"))
+	}
+	if c.GarbageCollection.Mode != GarbageCollectionDelete && c.GarbageCollection.Mode != GarbageCollectionReport {
+		problems = append(problems, fmt.Errorf("garbageCollection mode %q must be %s or %s", c.GarbageCollection.Mode, GarbageCollectionDelete, GarbageCollectionReport))
+	}
+	for _, secret := range c.GarbageCollection.Secrets {
+		if parts := strings.Split(secret, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			problems = append(problems, fmt.Errorf("garbageCollection secret %q must be namespace/name", secret))
+		}
+	}
+	if c.AlertStateInterval.Duration < 0 {
+		problems = append(problems, fmt.Errorf("alertStateInterval must be 0 or more"))
+	}
+	return problems
+}
+
+// Selector parses NamespaceSelector, nil when it is empty
+func (c Config) Selector() (labels.Selector, error) {
+	if strings.TrimSpace(c.NamespaceSelector) == "" {
+		return nil, nil
+	}
+	return labels.Parse(c.NamespaceSelector)
+}
+
+// Reload returns the configuration with the fields of next that can change while the controller
+// runs, and the names of the fields that changed but need a restart
+func (c Config) Reload(next Config) (Config, []string) {
+	reloaded := c
+	reloaded.DryRun = next.DryRun
+	reloaded.CheckMetrics = next.CheckMetrics
+	reloaded.Resync = next.Resync
+	reloaded.DefaultSecret = next.DefaultSecret
+	reloaded.DeletionPolicy = next.DeletionPolicy
+	reloaded.APIURL = next.APIURL
+	reloaded.Retry.MaxRetries = next.Retry.MaxRetries
+	reloaded.GarbageCollection.Mode = next.GarbageCollection.Mode
+	reloaded.GarbageCollection.Secrets = next.GarbageCollection.Secrets
+
+	var restart []string
+	if c.ClusterID != next.ClusterID {
+		restart = append(restart, "clusterID")
+	}
+	if c.Workers != next.Workers {
+		restart = append(restart, "workers")
+	}
+	if !reflect.DeepEqual(c.Namespaces, next.Namespaces) || c.NamespaceSelector != next.NamespaceSelector {
+		restart = append(restart, "namespaces")
+	}
+	if c.RateLimit != next.RateLimit {
+		restart = append(restart, "rateLimit")
+	}
+	if c.Retry.BaseDelay != next.Retry.BaseDelay || c.Retry.MaxDelay != next.Retry.MaxDelay {
+		restart = append(restart, "retry")
+	}
+	if c.GarbageCollection.Interval != next.GarbageCollection.Interval {
+		restart = append(restart, "garbageCollection")
+	}
+	if c.MetricsAddress != next.MetricsAddress {
+		restart = append(restart, "metricsAddress")
+	}
+	if c.AlertStateInterval != next.AlertStateInterval {
+		restart = append(restart, "alertStateInterval")
+	}
+	return reloaded, restart
+}
+
+// Watch reads the configuration file every interval until stopCh is closed, and calls reload with
+// it when its contents change and it is valid. Polling the contents rather than watching for events
+// follows the atomic symlink swap of a mounted ConfigMap.
+func Watch(path string, interval time.Duration, stopCh <-chan struct{}, reload func(Config)) {
+	last, _ := ioutil.ReadFile(path)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			glog.Warningf("Error reading configuration file %s: %v", path, err)
+			continue
+		}
+		if bytes.Equal(data, last) {
+			continue
+		}
+		last = data
+
+		cfg, err := Parse(data)
+		if err != nil {
+			glog.Warningf("Ignoring configuration file %s: %v", path, err)
+			continue
+		}
+		if problems := cfg.Validate(); len(problems) > 0 {
+			glog.Warningf("Ignoring invalid configuration file %s: %v", path, problems)
+			continue
+		}
+		reload(cfg)
+	}
+}
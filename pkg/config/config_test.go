@@ -0,0 +1,96 @@
+package config
+
+import (
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseOverDefaults(t *testing.T) {
+	cfg, err := Parse([]byte(`
+apiVersion: appoptics.io/v1
+kind: ControllerConfig
+workers: 4
+resync: 30s
+namespaces: [monitoring, web]
+deletionPolicy: Retain
+rateLimit:
+  qps: 2.5
+`))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 4, cfg.Workers)
+	assert.Equal(t, 30*time.Second, cfg.Resync.Duration)
+	assert.Equal(t, []string{"monitoring", "web"}, cfg.Namespaces)
+	assert.Equal(t, DeletionPolicyRetain, cfg.DeletionPolicy)
+	assert.Equal(t, 2.5, cfg.RateLimit.QPS)
+	// Unset fields keep their defaults
+	assert.Equal(t, Default().RateLimit.Burst, cfg.RateLimit.Burst)
+	assert.Equal(t, Default().DefaultSecret, cfg.DefaultSecret)
+	assert.Equal(t, 0, len(cfg.Validate()))
+}
+
+func TestParseUnsupportedVersion(t *testing.T) {
+	_, err := Parse([]byte("apiVersion: appoptics.io/v2\nkind: ControllerConfig\n"))
+	assert.NotEqual(t, nil, err)
+	_, err = Parse([]byte("workers: 2\n"))
+	assert.NotEqual(t, nil, err)
+
+	cfg, err := Parse(nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, Default(), cfg)
+}
+
+func TestValidate(t *testing.T) {
+	cfg := Default()
+	cfg.Workers = 0
+	cfg.NamespaceSelector = "team in (a"
+	cfg.DeletionPolicy = "Orphan"
+	cfg.APIURL = "api.appoptics.com"
+	cfg.Retry.MaxDelay = metav1.Duration{Duration: time.Second}
+	cfg.GarbageCollection.Mode = "Orphan"
+	cfg.GarbageCollection.Secrets = []string{"monitoring/appoptics", "appoptics"}
+	assert.Equal(t, 7, len(cfg.Validate()))
+
+	assert.Equal(t, 0, len(Default().Validate()))
+}
+
+func TestReload(t *testing.T) {
+	running := Default()
+	next := Default()
+	next.DryRun = true
+	next.DeletionPolicy = DeletionPolicyRetain
+	next.Retry.MaxRetries = 3
+	next.GarbageCollection.Mode = GarbageCollectionDelete
+	next.GarbageCollection.Secrets = []string{"monitoring/appoptics"}
+	next.Workers = 8
+	next.Namespaces = []string{"web"}
+
+	reloaded, restart := running.Reload(next)
+	assert.Equal(t, true, reloaded.DryRun)
+	assert.Equal(t, DeletionPolicyRetain, reloaded.DeletionPolicy)
+	assert.Equal(t, 3, reloaded.Retry.MaxRetries)
+	assert.Equal(t, GarbageCollectionDelete, reloaded.GarbageCollection.Mode)
+	assert.Equal(t, []string{"monitoring/appoptics"}, reloaded.GarbageCollection.Secrets)
+	assert.Equal(t, running.Workers, reloaded.Workers)
+	assert.Equal(t, 0, len(reloaded.Namespaces))
+	assert.Equal(t, []string{"workers", "namespaces"}, restart)
+}
+
+func TestFlagsOverrideSetFields(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := RegisterFlags(fs)
+	assert.Equal(t, nil, fs.Parse([]string{"-config", "/etc/appoptics/config.yaml", "-workers", "2", "-namespaces", "web, monitoring,", "-dry-run"}))
+
+	cfg := Default()
+	cfg.DefaultSecret = "from-file"
+	flags.Apply(&cfg)
+	assert.Equal(t, "/etc/appoptics/config.yaml", flags.File)
+	assert.Equal(t, 2, cfg.Workers)
+	assert.Equal(t, []string{"web", "monitoring"}, cfg.Namespaces)
+	assert.Equal(t, true, cfg.DryRun)
+	// Flags left unset do not replace the file's values with their defaults
+	assert.Equal(t, "from-file", cfg.DefaultSecret)
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"flag"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Flags are the command line equivalents of the configuration file. A flag only overrides the
+// file when it is set.
+type Flags struct {
+	File string
+
+	fs                 *flag.FlagSet
+	clusterID          string
+	workers            int
+	resync             time.Duration
+	namespaces         string
+	namespaceSelector  string
+	defaultSecret      string
+	deletionPolicy     string
+	apiURL             string
+	qps                float64
+	burst              int
+	maxRetries         int
+	gcInterval         time.Duration
+	gcMode             string
+	gcSecrets          string
+	metricsAddress     string
+	dryRun             bool
+	checkMetrics       bool
+	alertStateInterval time.Duration
+}
+
+// RegisterFlags adds the configuration flags to fs
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	d := Default()
+	f := &Flags{fs: fs}
+	fs.StringVar(&f.File, "config", "", "Path to a configuration file. Changes to its reloadable settings are applied without a restart.")
+	fs.StringVar(&f.clusterID, "cluster-id", d.ClusterID, "Marks the AppOptics objects this controller owns. The UID of the kube-system namespace when empty.")
+	fs.IntVar(&f.workers, "workers", d.Workers, "Number of resources synced at the same time.")
+	fs.DurationVar(&f.resync, "resync", d.Resync.Duration, "How often every resource is synced with AppOptics. Overrides the RESYNC_SECS environment variable.")
+	fs.StringVar(&f.namespaces, "namespaces", "", "Comma separated namespaces to watch, in addition to the NAMESPACE environment variable. All namespaces when neither this nor -namespace-selector is set.")
+	fs.StringVar(&f.namespaceSelector, "namespace-selector", "", "Label selector of the namespaces to watch, in addition to -namespaces. Namespaces are watched and unwatched as their labels change.")
+	fs.StringVar(&f.defaultSecret, "default-secret", d.DefaultSecret, "Secret holding the AppOptics token of resources that do not set spec.secret.")
+	fs.StringVar(&f.deletionPolicy, "deletion-policy", d.DeletionPolicy, "Delete or Retain the AppOptics resource when its custom resource is deleted.")
+	fs.StringVar(&f.apiURL, "api-url", d.APIURL, "AppOptics API endpoint, the client's default when empty.")
+	fs.Float64Var(&f.qps, "qps", d.RateLimit.QPS, "Resources synced per second, which bounds the calls made to the AppOptics API.")
+	fs.IntVar(&f.burst, "burst", d.RateLimit.Burst, "Resources synced in a burst above -qps.")
+	fs.IntVar(&f.maxRetries, "max-retries", d.Retry.MaxRetries, "Retries of a resource failing with a transient error before it waits for the next resync.")
+	fs.DurationVar(&f.gcInterval, "gc-interval", d.GarbageCollection.Interval.Duration, "How often AppOptics is swept for orphaned objects this controller owns, 0 to never.")
+	fs.StringVar(&f.gcMode, "gc-mode", d.GarbageCollection.Mode, "Delete or Report the orphaned objects the sweep finds.")
+	fs.StringVar(&f.gcSecrets, "gc-secrets", "", "Comma separated namespace/name of Secrets whose AppOptics accounts are swept even when no resource uses them.")
+	fs.StringVar(&f.metricsAddress, "metrics-address", d.MetricsAddress, "Address serving the controller's metrics at /debug/vars, e.g. :8080.")
+	fs.BoolVar(&f.dryRun, "dry-run", d.DryRun, "Plan the changes to AppOptics resources and report them as Events and in the status instead of applying them.")
+	fs.BoolVar(&f.checkMetrics, "check-metrics", d.CheckMetrics, "Check that the metrics used by dashboards and alerts exist in AppOptics, and warn about the ones that do not.")
+	fs.DurationVar(&f.alertStateInterval, "alert-state-interval", d.AlertStateInterval.Duration, "How often to copy whether alerts are firing in AppOptics to their status, 0 to never.")
+	return f
+}
+
+// Apply sets the fields of cfg whose flags were set
+func (f *Flags) Apply(cfg *Config) {
+	f.fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "cluster-id":
+			cfg.ClusterID = f.clusterID
+		case "workers":
+			cfg.Workers = f.workers
+		case "resync":
+			cfg.Resync = metav1.Duration{Duration: f.resync}
+		case "namespaces":
+			cfg.Namespaces = SplitNamespaces(f.namespaces)
+		case "namespace-selector":
+			cfg.NamespaceSelector = f.namespaceSelector
+		case "default-secret":
+			cfg.DefaultSecret = f.defaultSecret
+		case "deletion-policy":
+			cfg.DeletionPolicy = f.deletionPolicy
+		case "api-url":
+			cfg.APIURL = f.apiURL
+		case "qps":
+			cfg.RateLimit.QPS = f.qps
+		case "burst":
+			cfg.RateLimit.Burst = f.burst
+		case "max-retries":
+			cfg.Retry.MaxRetries = f.maxRetries
+		case "gc-interval":
+			cfg.GarbageCollection.Interval = metav1.Duration{Duration: f.gcInterval}
+		case "gc-mode":
+			cfg.GarbageCollection.Mode = f.gcMode
+		case "gc-secrets":
+			cfg.GarbageCollection.Secrets = SplitNamespaces(f.gcSecrets)
+		case "metrics-address":
+			cfg.MetricsAddress = f.metricsAddress
+		case "dry-run":
+			cfg.DryRun = f.dryRun
+		case "check-metrics":
+			cfg.CheckMetrics = f.checkMetrics
+		case "alert-state-interval":
+			cfg.AlertStateInterval = metav1.Duration{Duration: f.alertStateInterval}
+		}
+	})
+}
+
+// SplitNamespaces parses a comma separated list of namespaces
+func SplitNamespaces(list string) []string {
+	var namespaces []string
+	for _, namespace := range strings.Split(list, ",") {
+		if namespace = strings.TrimSpace(namespace); namespace != "" {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	return namespaces
+}
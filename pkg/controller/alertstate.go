@@ -0,0 +1,177 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	v12 "github.com/solarwinds/appoptics-kubernetes-controller/pkg/apis/appoptics-kubernetes-controller/v1"
+	"github.com/solarwinds/appoptics-kubernetes-controller/pkg/controller/appoptics"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/runtime"
+)
+
+const (
+	// AlertFiring is used as part of the Event 'reason' when an alert starts firing in AppOptics
+	AlertFiring = "AlertFiring"
+
+	// AlertCleared is used as part of the Event 'reason' when an alert stops firing in AppOptics
+	AlertCleared = "AlertCleared"
+
+	// MessageAlertFiring is the message used for Events when an alert starts firing
+	MessageAlertFiring = "Alert %s is firing"
+
+	// MessageAlertCleared is the message used for Events when an alert stops firing
+	MessageAlertCleared = "Alert %s cleared"
+
+	// WorkloadAnnotation names the deployment, statefulset, daemonset or pod, as kind/name in the
+	// alert's namespace, that also gets the Events of an alert firing and clearing
+	WorkloadAnnotation = "appoptics.io/workload"
+)
+
+// alertState is the part of the status of an alert recordAlertState applies
+type alertState struct {
+	Firing           *bool        `json:"firing"`
+	Active           *bool        `json:"active"`
+	FiringObservedAt *metav1.Time `json:"firingObservedAt,omitempty"`
+}
+
+// pollAlertStates mirrors whether every synced alert is firing and active in AppOptics into its status
+func (c *Controller) pollAlertStates() {
+	alerts, err := c.alertLister.List(labels.Everything())
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+
+	// Alerts sharing a token share a communicator
+	communicators := map[string]*appoptics.AOCommunicator{}
+	for _, alert := range alerts {
+		if alert.Status.ID == 0 || alert.DeletionTimestamp != nil {
+			continue
+		}
+		secretName := c.secretName(alert.Spec)
+		secretKey := alert.Namespace + "/" + secretName
+		aoc, ok := communicators[secretKey]
+		if !ok {
+			secret, err := c.kubeclientset.CoreV1().Secrets(alert.Namespace).Get(secretName, metav1.GetOptions{})
+			if err != nil {
+				runtime.HandleError(err)
+				continue
+			}
+			communicator, err := c.GetCommunicator(secret)
+			if err != nil {
+				runtime.HandleError(err)
+				continue
+			}
+			aoc = &communicator
+			communicators[secretKey] = aoc
+		}
+
+		firing, active, err := aoc.AlertState(alert.Status.ID)
+		if err != nil {
+			runtime.HandleError(fmt.Errorf("error getting the state of alert %s/%s: %s", alert.Namespace, alert.Name, err.Error()))
+			continue
+		}
+		c.recordAlertState(alert, firing, active, time.Now())
+	}
+}
+
+// recordAlertState updates the status of the alert if its state in AppOptics changed
+func (c *Controller) recordAlertState(alert *v12.AppOpticsAlert, firing, active bool, now time.Time) {
+	status := alert.Status
+	wasFiring := status.Firing != nil && *status.Firing
+	if status.Firing != nil && wasFiring == firing && status.Active != nil && *status.Active == active {
+		return
+	}
+
+	observedAt := status.FiringObservedAt
+	if firing && !wasFiring {
+		firedAt := metav1.NewTime(now)
+		observedAt = &firedAt
+	}
+	aoResource := CommonAOResource(*alert.DeepCopy())
+	var err error
+	if c.serverSideApply {
+		err = c.applyStatus(Alert, &aoResource, PollerFieldManager, alertState{Firing: &firing, Active: &active, FiringObservedAt: observedAt})
+	} else {
+		// Only the state is changed, a sync may have written the rest of the status since the alert was read
+		err = c.modifyResource(Alert, &aoResource, func(fresh *CommonAOResource) {
+			fresh.Status.Firing = &firing
+			fresh.Status.Active = &active
+			if firing && !wasFiring {
+				fresh.Status.FiringObservedAt = observedAt
+			}
+		})
+	}
+	if err != nil {
+		runtime.HandleError(fmt.Errorf("error updating the state of alert %s/%s: %s", alert.Namespace, alert.Name, err.Error()))
+		return
+	}
+
+	if firing == wasFiring {
+		return
+	}
+	eventType, reason, message := corev1.EventTypeNormal, AlertCleared, fmt.Sprintf(MessageAlertCleared, alert.Name)
+	if firing {
+		eventType, reason, message = corev1.EventTypeWarning, AlertFiring, fmt.Sprintf(MessageAlertFiring, alert.Name)
+	}
+	c.recorder.Event(toObject(Alert, &aoResource), eventType, reason, message)
+
+	workload, ok := alert.Annotations[WorkloadAnnotation]
+	if !ok {
+		return
+	}
+	ref, err := c.workloadReference(alert.Namespace, workload)
+	if err != nil {
+		runtime.HandleError(fmt.Errorf("error finding the workload of alert %s/%s: %s", alert.Namespace, alert.Name, err.Error()))
+		return
+	}
+	c.recorder.Event(ref, eventType, reason, message)
+}
+
+// workloadReference returns a reference to the workload named kind/name in the namespace
+func (c *Controller) workloadReference(namespace, workload string) (*corev1.ObjectReference, error) {
+	parts := strings.SplitN(workload, "/", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, fmt.Errorf("%s must be kind/name, got %q", WorkloadAnnotation, workload)
+	}
+	kind, name := strings.ToLower(parts[0]), parts[1]
+
+	ref := &corev1.ObjectReference{Namespace: namespace, Name: name, APIVersion: "apps/v1"}
+	var objectMeta *metav1.ObjectMeta
+	switch kind {
+	case "deployment":
+		deployment, err := c.kubeclientset.AppsV1().Deployments(namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		ref.Kind, objectMeta = "Deployment", &deployment.ObjectMeta
+	case "statefulset":
+		statefulSet, err := c.kubeclientset.AppsV1().StatefulSets(namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		ref.Kind, objectMeta = "StatefulSet", &statefulSet.ObjectMeta
+	case "daemonset":
+		daemonSet, err := c.kubeclientset.AppsV1().DaemonSets(namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		ref.Kind, objectMeta = "DaemonSet", &daemonSet.ObjectMeta
+	case "pod":
+		pod, err := c.kubeclientset.CoreV1().Pods(namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		ref.Kind, ref.APIVersion, objectMeta = "Pod", "v1", &pod.ObjectMeta
+	default:
+		return nil, fmt.Errorf("%s kind must be deployment, statefulset, daemonset or pod, got %q", WorkloadAnnotation, parts[0])
+	}
+	// kubectl describe only shows the Events of the object with the same UID
+	ref.UID = objectMeta.UID
+	ref.ResourceVersion = objectMeta.ResourceVersion
+	return ref, nil
+}
@@ -0,0 +1,118 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	v12 "github.com/solarwinds/appoptics-kubernetes-controller/pkg/apis/appoptics-kubernetes-controller/v1"
+	aofake "github.com/solarwinds/appoptics-kubernetes-controller/pkg/client/clientset/versioned/fake"
+	"github.com/solarwinds/appoptics-kubernetes-controller/pkg/config"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+// testObjectRecorder is a FakeRecorder that also records the kind/name of the object of each event
+type testObjectRecorder struct {
+	*record.FakeRecorder
+	objects []string
+}
+
+func (r *testObjectRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	switch object := object.(type) {
+	case *v12.AppOpticsAlert:
+		r.objects = append(r.objects, "AppOpticsAlert/"+object.Name)
+	case *corev1.ObjectReference:
+		r.objects = append(r.objects, object.Kind+"/"+object.Name+"/"+string(object.UID))
+	}
+	r.FakeRecorder.Event(object, eventtype, reason, message)
+}
+
+func newTestStateAlert(firing *bool, annotations map[string]string) *v12.AppOpticsAlert {
+	return &v12.AppOpticsAlert{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "errors", Annotations: annotations},
+		Status:     v12.Status{ID: 1, Firing: firing, Active: firing},
+	}
+}
+
+// newTestStateController returns a controller storing the alert and the deployment web it may name,
+// recording the objects of its events
+func newTestStateController(alert *v12.AppOpticsAlert) (*Controller, *testObjectRecorder) {
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "web", UID: types.UID("uid-1")}}
+	c := newTestController(config.Default(), alert, deployment)
+	recorder := &testObjectRecorder{FakeRecorder: c.recorder.(*record.FakeRecorder)}
+	c.recorder = recorder
+	return c, recorder
+}
+
+func storedAlert(t *testing.T, c *Controller) *v12.AppOpticsAlert {
+	alert, err := c.aoclientset.AppopticsV1().AppOpticsAlerts("web").Get("errors", metav1.GetOptions{})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	return alert
+}
+
+func TestAlertFiringReportedOnAlertAndWorkload(t *testing.T) {
+	notFiring := false
+	c, recorder := newTestStateController(newTestStateAlert(&notFiring, map[string]string{WorkloadAnnotation: "Deployment/web"}))
+	now := time.Now()
+
+	c.recordAlertState(storedAlert(t, c), true, true, now)
+	assert.Equal(t, []string{"Warning AlertFiring Alert errors is firing", "Warning AlertFiring Alert errors is firing"}, testEvents(c))
+	assert.Equal(t, []string{"AppOpticsAlert/errors", "Deployment/web/uid-1"}, recorder.objects)
+
+	alert := storedAlert(t, c)
+	assert.True(t, *alert.Status.Firing)
+	assert.True(t, *alert.Status.Active)
+	assert.Equal(t, now.Unix(), alert.Status.FiringObservedAt.Unix())
+}
+
+func TestAlertClearedReportedOnAlertAndWorkload(t *testing.T) {
+	firing := true
+	c, recorder := newTestStateController(newTestStateAlert(&firing, map[string]string{WorkloadAnnotation: "deployment/web"}))
+
+	c.recordAlertState(storedAlert(t, c), false, true, time.Now())
+	assert.Equal(t, []string{"Normal AlertCleared Alert errors cleared", "Normal AlertCleared Alert errors cleared"}, testEvents(c))
+	assert.Equal(t, []string{"AppOpticsAlert/errors", "Deployment/web/uid-1"}, recorder.objects)
+	assert.False(t, *storedAlert(t, c).Status.Firing)
+}
+
+func TestAlertStateReportedOnAlertOnly(t *testing.T) {
+	notFiring := false
+	c, recorder := newTestStateController(newTestStateAlert(&notFiring, nil))
+
+	c.recordAlertState(storedAlert(t, c), true, true, time.Now())
+	assert.Equal(t, []string{"Warning AlertFiring Alert errors is firing"}, testEvents(c))
+	assert.Equal(t, []string{"AppOpticsAlert/errors"}, recorder.objects)
+}
+
+func TestUnchangedAlertStateNotReported(t *testing.T) {
+	firing := true
+	c, _ := newTestStateController(newTestStateAlert(&firing, map[string]string{WorkloadAnnotation: "deployment/web"}))
+	alert := storedAlert(t, c)
+
+	c.recordAlertState(alert, true, true, time.Now())
+	assert.Equal(t, 0, len(testEvents(c)))
+	for _, action := range c.aoclientset.(*aofake.Clientset).Actions() {
+		assert.NotEqual(t, "update", action.GetVerb())
+	}
+
+	// Only the activity changed, which is stored without an event
+	c.recordAlertState(alert, true, false, time.Now())
+	assert.Equal(t, 0, len(testEvents(c)))
+	assert.False(t, *storedAlert(t, c).Status.Active)
+}
+
+// Tests that the first state seen of an alert that is not firing is stored without an event
+func TestFirstAlertStateNotFiringNotReported(t *testing.T) {
+	c, _ := newTestStateController(newTestStateAlert(nil, nil))
+
+	c.recordAlertState(storedAlert(t, c), false, true, time.Now())
+	assert.Equal(t, 0, len(testEvents(c)))
+	assert.False(t, *storedAlert(t, c).Status.Firing)
+}
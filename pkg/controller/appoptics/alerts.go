@@ -2,138 +2,345 @@ package appoptics
 
 import (
 	"bytes"
-	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
 	aoApi "github.com/appoptics/appoptics-api-go"
 	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
 	"github.com/solarwinds/appoptics-kubernetes-controller/pkg/apis/appoptics-kubernetes-controller/v1"
 	listers "github.com/solarwinds/appoptics-kubernetes-controller/pkg/client/listers/appoptics-kubernetes-controller/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	// AlertTriggered is the status AppOptics reports for a firing alert
+	AlertTriggered = "triggered"
+
+	// ServicesReady is the reason of the ServicesReady condition when every referenced service is synced
+	ServicesReady = "ServicesReady"
+	// ServicesNotReady is the reason of the ServicesReady condition when a referenced service is
+	// missing, has not been synced to AppOptics yet or belongs to another AppOptics account
+	ServicesNotReady = "ServicesNotReady"
 )
 
+// AccountChecker reports whether an AppOpticsService is synced to the AppOptics account of the alert
+// referencing it. The ID of a service in another account means nothing to the alert's account.
+type AccountChecker func(service *v1.AppOpticsService) (bool, error)
+
 type AlertsService struct {
 	aoApi.AlertsService
-	client aoApi.Client
-	lister listers.AppOpticsServiceNamespaceLister
+	client    aoApi.Client
+	lister    listers.AppOpticsServiceLister
+	namespace string
+	plan      *Plan
+	// maintenanceWindows lists the open maintenance windows muting the alert
+	maintenanceWindows []string
+	owner              Ownership
+	checkpoint         Checkpoint
+	// sameAccount checks referenced services are in the alert's account, nil accepts every service
+	sameAccount AccountChecker
 }
 
-func NewAlertsService(c *aoApi.Client, lister listers.AppOpticsServiceNamespaceLister) *AlertsService {
-	return &AlertsService{*aoApi.NewAlertsService(c), *c, lister}
+func NewAlertsService(c *aoApi.Client, lister listers.AppOpticsServiceLister, namespace string) *AlertsService {
+	return &AlertsService{*aoApi.NewAlertsService(c), *c, lister, namespace, nil, nil, Ownership{}, nil, nil}
 }
 
 func (as *AlertsService) Sync(spec v1.TokenAndDataSpec, status *v1.Status) (*v1.Status, error) {
-	specString, err := json.Marshal(spec)
+	customAlert, err := ParseAlert(spec.Data)
 	if err != nil {
 		return nil, err
 	}
-	specHash, err := Hash([]byte(specString))
+
+	notificationServices, err := as.resolveServices(spec, customAlert, status)
 	if err != nil {
 		return nil, err
 	}
-	specChanged := bytes.Compare(specHash, status.Hashes.Spec) != 0
-	if specChanged {
-		status.Hashes.Spec = specHash
+	sort.Slice(notificationServices, func(i, j int) bool {
+		return *notificationServices[i].ID < *notificationServices[j].ID
+	})
+	customAlert.Services = notificationServices
+
+	// An alert is muted by deactivating it, the spec's value is restored once no window is open
+	if len(as.maintenanceWindows) > 0 {
+		inactive := false
+		customAlert.Active = &inactive
+	} else if customAlert.Active == nil {
+		active := true
+		customAlert.Active = &active
 	}
-	var customAlert aoApi.Alert
-	err = yaml.Unmarshal([]byte(spec.Data), &customAlert)
+	as.owner.MarkAlert(&customAlert)
+
+	// The desired alert covers the resolved service IDs and muting, not just the spec
+	specHash, err := Hash(customAlert)
 	if err != nil {
 		return nil, err
 	}
 
-	var notificationServices []*aoApi.Service
-	if services, ok := customAlert.Attributes["services"]; ok {
-		for _, serviceObj := range services.([]interface{}) {
-			serviceStr := serviceObj.(string)
-			service, err := as.lister.Get(serviceStr)
+	// If we dont have an ID for it then we assume its new and create it
+	if status.ID == 0 {
+		return as.createAlert(customAlert, specHash, status)
+	}
+	// Lets ensure that the ID we have exists in AppOptics
+	aoAlert, err := as.Retrieve(status.ID)
+	if err != nil {
+		// If its a not found error thats ok we can try to create it now
+		if CheckIfErrorIsAppOpticsNotFoundError(err, Alert, status.ID) {
+			return as.createAlert(customAlert, specHash, status)
+		}
+		return nil, err
+	}
+	return as.updateAlert(customAlert, specHash, aoAlert, status)
+}
+
+// updateAlert brings an existing AppOptics alert, and the services associated to it, in line with
+// the desired alert
+func (as *AlertsService) updateAlert(customAlert aoApi.Alert, specHash []byte, aoAlert *aoApi.Alert, status *v1.Status) (*v1.Status, error) {
+	owner, marked := AlertOwnership(aoAlert)
+	if err := as.owner.checkOwner(Alert, *aoAlert.ID, owner, marked); err != nil {
+		return nil, err
+	}
+	specChanged := !bytes.Equal(specHash, status.Hashes.Spec)
+	// The alert is hashed as AppOptics returns it, before the associations below change it
+	aoHash, err := Hash(aoAlert)
+	if err != nil {
+		return nil, err
+	}
+	associationsChanged := false
+
+	//Associate services, remove any already associated services and delete any non existing associations
+	notificationServices := append([]*aoApi.Service(nil), customAlert.Services...)
+	for _, service := range aoAlert.Services {
+		index := -1
+		for idx, customService := range notificationServices {
+			if *customService.ID == *service.ID {
+				index = idx
+			}
+		}
+
+		if index != -1 {
+			notificationServices = append(notificationServices[:index], notificationServices[index+1:]...)
+		} else if !as.plan.Skip("disassociate service %d from alert %d", *service.ID, *aoAlert.ID) {
+			err := as.DisassociateFromService(*aoAlert.ID, *service.ID)
 			if err != nil {
 				return nil, err
 			}
-			if service != nil && service.Status.ID != 0 {
-				notificationServices = append(notificationServices, &aoApi.Service{ID: &service.Status.ID})
-			}
+			associationsChanged = true
 		}
+
 	}
+	for _, service := range notificationServices {
+		if as.plan.Skip("associate service %d to alert %d", *service.ID, *aoAlert.ID) {
+			continue
+		}
+		err := as.AssociateToService(*aoAlert.ID, *service.ID)
+		if err != nil {
+			return nil, err
+		}
+		associationsChanged = true
+	}
+	// The alert was changed in AppOptics when it no longer matches the hash taken at the last sync
+	if specChanged || !bytes.Equal(aoHash, status.Hashes.AppOptics) {
+		// Local vs Remote are different so update AO
+		//SET THE ALERT ID FOR THE OBJECT ABOUT TO BE PUT
+		customAlert.ID = aoAlert.ID
+		if as.plan.Skip("update alert %d", *aoAlert.ID) {
+			return status, nil
+		}
 
-	customAlert.Services = notificationServices
-	// If we dont have an ID for it then we assume its new and create it
-	if status.ID == 0 {
-		return as.createAlert(customAlert, status)
-	} else {
-		// Lets ensure that the ID we have exists in AppOptics
-		aoAlert, err := as.Retrieve(status.ID)
+		// Update the alert
+		err := as.Update(&customAlert)
 		if err != nil {
-			// If its a not found error thats ok we can try to create it now
-			if CheckIfErrorIsAppOpticsNotFoundError(err, Alert, status.ID) {
-				return as.createAlert(customAlert, status)
-			} else {
-				return nil, err
-			}
-		} else {
-			//Associate services, remove any already associated services and delete any non existing associations
-			for _, service := range aoAlert.Services {
-				index := -1
-				for idx, customService := range notificationServices {
-					if *customService.ID == *service.ID {
-						index = idx
-					}
-				}
-
-				if index != -1 {
-					notificationServices = append(notificationServices[:index], notificationServices[index+1:]...)
-				} else {
-					err := as.DisassociateFromService(*aoAlert.ID, *service.ID)
-					if err != nil {
-						return nil, err
-					}
-				}
+			return nil, err
+		}
 
+		// Retrieve the Updated alert
+		aoAlert, err = as.Retrieve(*aoAlert.ID)
+		if err != nil {
+			return nil, err
+		}
+		// Store the Hashes
+		status.Hashes.AppOptics, err = Hash(aoAlert)
+		if err != nil {
+			return nil, err
+		}
+		status.Hashes.Spec = specHash
+		status.UpdatedAt = *aoAlert.UpdatedAt
+	} else if associationsChanged {
+		// The associated services are part of the alert AppOptics returns, so they are hashed again
+		// rather than taken for a change made in AppOptics at the next sync
+		aoAlert, err = as.Retrieve(*aoAlert.ID)
+		if err != nil {
+			return nil, err
+		}
+		status.Hashes.AppOptics, err = Hash(aoAlert)
+		if err != nil {
+			return nil, err
+		}
+	}
+	status.MaintenanceWindows = as.maintenanceWindows
+	return status, nil
+}
+
+// resolveServices looks up the AppOpticsServices the alert references. Missing and unsynced services
+// are left out and reported in the ServicesReady condition, the alert is requeued once they are ready.
+func (as *AlertsService) resolveServices(spec v1.TokenAndDataSpec, alert aoApi.Alert, status *v1.Status) ([]*aoApi.Service, error) {
+	refs, err := AlertServiceRefs(as.namespace, spec, alert)
+	if err != nil {
+		return nil, err
+	}
+	var notificationServices []*aoApi.Service
+	var missing, pending, foreign []string
+	for _, ref := range refs {
+		if as.lister == nil {
+			return nil, fmt.Errorf("no lister to look up service %s/%s", ref.Namespace, ref.Name)
+		}
+		service, err := as.lister.AppOpticsServices(ref.Namespace).Get(ref.Name)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				missing = append(missing, ref.Namespace+"/"+ref.Name)
+				continue
 			}
-			for _, service := range notificationServices {
-				err = as.AssociateToService(*aoAlert.ID, *service.ID)
-				if err != nil {
-					return nil, err
-				}
+			return nil, err
+		}
+		if service.Status.ID == 0 {
+			pending = append(pending, ref.Namespace+"/"+ref.Name)
+			continue
+		}
+		if as.sameAccount != nil {
+			same, err := as.sameAccount(service)
+			if err != nil {
+				return nil, err
 			}
-			//Service exists in AppOptics now lets check that they are actually synced
-			if status.UpdatedAt != *aoAlert.UpdatedAt || specChanged {
-				// Local vs Remote are different so update AO
-				//SET THE ALERT ID FOR THE OBJECT ABOUT TO BE PUT
-				customAlert.ID = aoAlert.ID
-
-				// Update the alert
-				err = as.Update(&customAlert)
-				if err != nil {
-					return nil, err
-				}
-
-				// Retrieve the Updated alert
-				aoAlert, err = as.Retrieve(status.ID)
-				if err != nil {
-					return nil, err
-				}
-				// Hash the current AO Object
-				hash, err := json.Marshal(aoAlert)
-				if err != nil {
-					return nil, err
-				}
-
-				// Store the Hashes
-				status.Hashes.AppOptics = hash
-				status.UpdatedAt = *aoAlert.UpdatedAt
+			if !same {
+				foreign = append(foreign, ref.Namespace+"/"+ref.Name)
+				continue
 			}
 		}
+		ID := service.Status.ID
+		notificationServices = append(notificationServices, &aoApi.Service{ID: &ID})
 	}
-	return status, nil
+
+	if len(missing) == 0 && len(pending) == 0 && len(foreign) == 0 {
+		status.SetCondition(v1.ConditionServicesReady, v1.ConditionTrue, ServicesReady, "")
+		return notificationServices, nil
+	}
+	var problems []string
+	if len(missing) > 0 {
+		problems = append(problems, "not found: "+strings.Join(missing, ", "))
+	}
+	if len(pending) > 0 {
+		problems = append(problems, "not synced yet: "+strings.Join(pending, ", "))
+	}
+	if len(foreign) > 0 {
+		problems = append(problems, "in another AppOptics account: "+strings.Join(foreign, ", "))
+	}
+	status.SetCondition(v1.ConditionServicesReady, v1.ConditionFalse, ServicesNotReady, strings.Join(problems, "; "))
+	return notificationServices, nil
+}
+
+// AlertServiceRefs returns the AppOpticsServices an alert in the given namespace references, from
+// spec.serviceRefs and the service names in the alert's attributes.services
+func AlertServiceRefs(namespace string, spec v1.TokenAndDataSpec, alert aoApi.Alert) ([]v1.ObjectReference, error) {
+	names, err := AlertServiceNames(alert)
+	if err != nil {
+		return nil, err
+	}
+	var refs []v1.ObjectReference
+	for _, name := range names {
+		refs = append(refs, v1.ObjectReference{Namespace: namespace, Name: name})
+	}
+	for i, ref := range spec.ServiceRefs {
+		if ref.Name == "" {
+			return nil, NewPermanentError(fmt.Errorf("serviceRefs[%d]: name is required", i))
+		}
+		if ref.Namespace == "" {
+			ref.Namespace = namespace
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+// State reports whether the alert is firing and whether it is active in AppOptics
+func (as *AlertsService) State(ID int) (firing bool, active bool, err error) {
+	alertStatus, err := as.Status(ID)
+	if err != nil {
+		return false, false, err
+	}
+	aoAlert, err := as.Retrieve(ID)
+	if err != nil {
+		return false, false, err
+	}
+	return alertStatus.Status == AlertTriggered, aoAlert.Active != nil && *aoAlert.Active, nil
+}
+
+// FindByName returns the AppOptics alert with the given name, or nil if there is none
+func (as *AlertsService) FindByName(name string) (*aoApi.Alert, error) {
+	alerts, err := as.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, alert := range alerts.Alerts {
+		if stringValue(alert.Name) == name {
+			return alert, nil
+		}
+	}
+	return nil, nil
+}
+
+// ParseAlert decodes the spec.data of an AppOpticsAlert
+func ParseAlert(data string) (aoApi.Alert, error) {
+	var alert aoApi.Alert
+	err := yaml.Unmarshal([]byte(data), &alert)
+	if err != nil {
+		return alert, NewPermanentError(err)
+	}
+	return alert, nil
 }
 
-func (as *AlertsService) createAlert(alert aoApi.Alert, status *v1.Status) (*v1.Status, error) {
+func (as *AlertsService) createAlert(alert aoApi.Alert, specHash []byte, status *v1.Status) (*v1.Status, error) {
+	// Alert names are unique, so an alert with the name marked as this resource's is one created by
+	// an earlier sync whose ID was never stored, which is adopted rather than duplicated. An alert of
+	// anything else keeps the name taken.
+	existing, err := as.FindByName(stringValue(alert.Name))
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil && existing.ID != nil {
+		if owner, marked := AlertOwnership(existing); !as.owner.IsZero() && (!marked || !as.owner.sameResource(owner)) {
+			return nil, NewPermanentError(fmt.Errorf("alert name %q is taken by alert %d, which is not owned by %s/%s", stringValue(alert.Name), *existing.ID, as.owner.Namespace, as.owner.Name))
+		}
+		aoAlert, err := as.Retrieve(*existing.ID)
+		if err != nil {
+			return nil, err
+		}
+		glog.Infof("Adopting existing alert %d %q", *aoAlert.ID, stringValue(aoAlert.Name))
+		status.ID = *aoAlert.ID
+		if err := as.checkpoint.save(status.ID); err != nil {
+			return nil, err
+		}
+		return as.updateAlert(alert, specHash, aoAlert, status)
+	}
+
 	//Associate services
 	services := alert.Services
 	// Nil out as the current Alert.Services struct is not an array of ints
 	alert.Services = nil
 
+	if as.plan.Skip("create alert %q with %d services", stringValue(alert.Name), len(services)) {
+		return status, nil
+	}
+
 	aoAlert, err := as.Create(&alert)
 	if err != nil {
 		return nil, err
 	}
+	status.ID = *aoAlert.ID
+	if err := as.checkpoint.save(status.ID); err != nil {
+		return nil, err
+	}
 
 	// Associate Services to the Alert
 	for _, service := range services {
@@ -142,12 +349,19 @@ func (as *AlertsService) createAlert(alert aoApi.Alert, status *v1.Status) (*v1.
 			return nil, err
 		}
 	}
-	status.ID = *aoAlert.ID
+	if len(services) > 0 {
+		// Hash the alert with its services, as the next sync retrieves it
+		aoAlert, err = as.Retrieve(*aoAlert.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
 	status.UpdatedAt = *aoAlert.UpdatedAt
-	hash, err := json.Marshal(aoAlert)
+	status.Hashes.AppOptics, err = Hash(aoAlert)
 	if err != nil {
 		return nil, err
 	}
-	status.Hashes.AppOptics = hash
+	status.Hashes.Spec = specHash
+	status.MaintenanceWindows = as.maintenanceWindows
 	return status, nil
 }
@@ -13,6 +13,8 @@ import (
 
 const errorName = "Error"
 
+const testFiringAlertId int = 7
+
 func TestExistingAlertSyncSuccess(t *testing.T) {
 
 	data := `
@@ -38,7 +40,7 @@ func TestExistingAlertSyncSuccess(t *testing.T) {
 	ts := v1.Status{ID: 3, LastUpdated: "Yesterday"}
 	td := v1.TokenAndDataSpec{Namespace: "Default", Data: data, Secret: "blah"}
 
-	ts1, err := aoc.Sync(td, &ts, Alert, NewMockLister())
+	ts1, err := aoc.Sync(td, &ts, Alert, SyncContext{Services: NewMockLister()})
 	if err != nil {
 		t.Errorf("error running TestExistingServiceSync: %v", err)
 	}
@@ -71,7 +73,7 @@ func TestExistingAlertSyncWithNewServiceSuccess(t *testing.T) {
 	ts := v1.Status{ID: 3, LastUpdated: "Yesterday"}
 	td := v1.TokenAndDataSpec{Namespace: "Default", Data: data, Secret: "blah"}
 
-	ts1, err := aoc.Sync(td, &ts, Alert, NewMockLister())
+	ts1, err := aoc.Sync(td, &ts, Alert, SyncContext{Services: NewMockLister()})
 	if err != nil {
 		t.Errorf("error running TestExistingServiceSync: %v", err)
 	}
@@ -79,13 +81,35 @@ func TestExistingAlertSyncWithNewServiceSuccess(t *testing.T) {
 	assert.Equal(t, ts1.ID, ts.ID)
 }
 
+// Tests that an unchanged alert is left alone and that an alert changed in AppOptics is updated
+func TestExistingAlertSyncDetectsDrift(t *testing.T) {
+	ts := v1.Status{ID: 3}
+	data := `{"name": "TEST", "description": "ActiveControllerCount", "rearm_seconds": 120}`
+	td := v1.TokenAndDataSpec{Namespace: "Default", Data: data, Secret: "blah"}
+
+	synced, err := aoc.Sync(td, &ts, Alert, SyncContext{Services: NewMockLister()})
+	assert.Nil(t, err)
+	assert.NotEmpty(t, synced.Hashes.Spec)
+	assert.NotEmpty(t, synced.Hashes.AppOptics)
+
+	synced.UpdatedAt = 0
+	synced, err = aoc.Sync(td, synced, Alert, SyncContext{Services: NewMockLister()})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, synced.UpdatedAt)
+
+	synced.Hashes.AppOptics = []byte("changed in AppOptics")
+	synced, err = aoc.Sync(td, synced, Alert, SyncContext{Services: NewMockLister()})
+	assert.Nil(t, err)
+	assert.NotEqual(t, 0, synced.UpdatedAt)
+}
+
 func TestExistingAlertNotInAppOpticsSyncSuccess(t *testing.T) {
 	data := `
     {
      "name": "testName"
 	}`
 	alertSpec := v1.TokenAndDataSpec{Namespace: "Default", Data: data, Secret: ""}
-	ts1, err := aoc.Sync(alertSpec, &v1.Status{ID: testNotFoundId}, Alert, NewMockLister())
+	ts1, err := aoc.Sync(alertSpec, &v1.Status{ID: testNotFoundId}, Alert, SyncContext{Services: NewMockLister()})
 	if err != nil {
 		t.Errorf("error running TestExistingServiceSync: %v", err)
 	}
@@ -99,7 +123,7 @@ func TestExistingAlertNotInAppOpticsSyncFailure(t *testing.T) {
      "name": ` + errorName + `
 	}`
 	alertSpec := v1.TokenAndDataSpec{Namespace: "Default", Data: data, Secret: ""}
-	_, err := aoc.Sync(alertSpec, &v1.Status{ID: testNotFoundId}, Alert, NewMockLister())
+	_, err := aoc.Sync(alertSpec, &v1.Status{ID: testNotFoundId}, Alert, SyncContext{Services: NewMockLister()})
 	assert.NotEqual(t, nil, err)
 }
 
@@ -110,24 +134,157 @@ func TestNewAlertSyncSuccess(t *testing.T) {
      "name": "newAlert"
 	}`
 	alertSpec := v1.TokenAndDataSpec{Namespace: "Default", Data: data, Secret: ""}
-	ID, err := aoc.Sync(alertSpec, &v1.Status{ID: 0}, Alert, NewMockLister())
+	ID, err := aoc.Sync(alertSpec, &v1.Status{ID: 0}, Alert, SyncContext{Services: NewMockLister()})
 	assert.Equal(t, nil, err)
 	assert.NotEqual(t, 0, ID)
 }
 
+// Tests that an alert with the same name, left by a sync whose ID was never stored, is adopted
+// rather than duplicated. The test server retrieves every alert as 123.
+func TestNewAlertSyncAdoptsExistingAlert(t *testing.T) {
+	owned := &AOCommunicator{Client: *client, Owner: Ownership{ClusterID: "this-cluster", Namespace: "web", Name: "errors"}}
+	td := v1.TokenAndDataSpec{Namespace: "web", Data: "name: production.web.frontend.errors"}
+
+	ts, err := owned.Sync(td, &v1.Status{}, Alert, SyncContext{Services: NewMockLister(), Namespace: "web"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 123, ts.ID)
+}
+
+// Tests that an alert of another resource, or an unmarked one, keeps its name rather than being adopted
+func TestNewAlertSyncNameTaken(t *testing.T) {
+	owned := &AOCommunicator{Client: *client, Owner: Ownership{ClusterID: "this-cluster", Namespace: "web", Name: "other-errors"}}
+	td := v1.TokenAndDataSpec{Namespace: "web", Data: "name: production.web.frontend.errors"}
+
+	_, err := owned.Sync(td, &v1.Status{}, Alert, SyncContext{Services: NewMockLister(), Namespace: "web"})
+	assert.NotEqual(t, nil, err)
+	assert.True(t, IsPermanentError(err))
+
+	td = v1.TokenAndDataSpec{Namespace: "web", Data: "name: production.web.frontend.response_time"}
+	_, err = owned.Sync(td, &v1.Status{}, Alert, SyncContext{Services: NewMockLister(), Namespace: "web"})
+	assert.NotEqual(t, nil, err)
+	assert.True(t, IsPermanentError(err))
+}
+
 func TestNewAlertSyncFailure(t *testing.T) {
 	data := `
     {
      "name": "Error"
 	}`
 	alertSpec := v1.TokenAndDataSpec{Namespace: "Default", Data: data, Secret: ""}
-	_, err := aoc.Sync(alertSpec, &v1.Status{ID: 0}, Alert, NewMockLister())
+	_, err := aoc.Sync(alertSpec, &v1.Status{ID: 0}, Alert, SyncContext{Services: NewMockLister()})
+	assert.NotEqual(t, nil, err)
+}
+
+func TestNewAlertSyncWithServiceRefs(t *testing.T) {
+	data := `
+    {
+     "name": "newAlert"
+	}`
+	alertSpec := v1.TokenAndDataSpec{Namespace: "Default", Data: data, Secret: "", ServiceRefs: []v1.ObjectReference{
+		{Name: "example"},
+		{Namespace: "monitoring", Name: "pagerduty"},
+	}}
+	ts, err := aoc.Sync(alertSpec, &v1.Status{ID: 0}, Alert, SyncContext{Namespace: "default", Services: NewMockLister()})
+	assert.Equal(t, nil, err)
+	assert.True(t, ts.IsConditionTrue(v1.ConditionServicesReady))
+}
+
+func TestAlertSyncWithUnreadyServiceRefs(t *testing.T) {
+	data := `
+    {
+     "name": "newAlert"
+	}`
+	alertSpec := v1.TokenAndDataSpec{Namespace: "Default", Data: data, Secret: "", ServiceRefs: []v1.ObjectReference{
+		{Name: "example"},
+		{Name: testMissingServiceName},
+		{Namespace: "monitoring", Name: testPendingServiceName},
+	}}
+	ts, err := aoc.Sync(alertSpec, &v1.Status{ID: 0}, Alert, SyncContext{Namespace: "default", Services: NewMockLister()})
+	assert.Equal(t, nil, err)
+	assert.NotEqual(t, 0, ts.ID)
+
+	condition := ts.GetCondition(v1.ConditionServicesReady)
+	assert.Equal(t, v1.ConditionFalse, condition.Status)
+	assert.Equal(t, ServicesNotReady, condition.Reason)
+	assert.Equal(t, "not found: default/missing; not synced yet: monitoring/pending", condition.Message)
+}
+
+func TestAlertSyncWithServiceRefInAnotherAccount(t *testing.T) {
+	alertSpec := v1.TokenAndDataSpec{Namespace: "Default", Data: `name: newAlert`, ServiceRefs: []v1.ObjectReference{
+		{Name: "example"},
+		{Namespace: "monitoring", Name: "pagerduty"},
+	}}
+	sameAccount := func(service *v1.AppOpticsService) (bool, error) {
+		return service.Namespace == "default", nil
+	}
+	ts, err := aoc.Sync(alertSpec, &v1.Status{ID: 0}, Alert, SyncContext{Namespace: "default", Services: NewMockLister(), SameAccount: sameAccount})
+	assert.Equal(t, nil, err)
+
+	condition := ts.GetCondition(v1.ConditionServicesReady)
+	assert.Equal(t, v1.ConditionFalse, condition.Status)
+	assert.Equal(t, "in another AppOptics account: monitoring/pagerduty", condition.Message)
+}
+
+func TestAlertServiceRefsWithoutNameIsPermanentError(t *testing.T) {
+	alertSpec := v1.TokenAndDataSpec{Data: `name: newAlert`, ServiceRefs: []v1.ObjectReference{{Namespace: "monitoring"}}}
+	_, err := aoc.Sync(alertSpec, &v1.Status{ID: 0}, Alert, SyncContext{Namespace: "default", Services: NewMockLister()})
+	assert.True(t, IsPermanentError(err))
+}
+
+func TestAlertSyncInMaintenanceWindow(t *testing.T) {
+	alertSpec := v1.TokenAndDataSpec{Data: `name: "TEST"`}
+	ts, err := aoc.Sync(alertSpec, &v1.Status{ID: 3}, Alert, SyncContext{MaintenanceWindows: []string{"deploy"}})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"deploy"}, ts.MaintenanceWindows)
+
+	// Closing the window unmutes the alert
+	ts, err = aoc.Sync(alertSpec, ts, Alert, SyncContext{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(ts.MaintenanceWindows))
+}
+
+func TestFiringAlertState(t *testing.T) {
+	firing, active, err := aoc.AlertState(testFiringAlertId)
+	assert.Equal(t, nil, err)
+	assert.True(t, firing)
+	assert.True(t, active)
+}
+
+func TestClearedAlertState(t *testing.T) {
+	firing, _, err := aoc.AlertState(3)
+	assert.Equal(t, nil, err)
+	assert.False(t, firing)
+}
+
+func TestAlertStateError(t *testing.T) {
+	_, _, err := aoc.AlertState(testNotFoundId)
 	assert.NotEqual(t, nil, err)
 }
 
+func TestAlertSpecHashIsCanonical(t *testing.T) {
+	yamlSpec := v1.TokenAndDataSpec{Secret: "appoptics", Data: `
+name: newAlert
+rearm_seconds: 120
+`}
+	jsonSpec := v1.TokenAndDataSpec{Secret: "other", Data: `{"rearm_seconds": 120, "name": "newAlert"}`}
+
+	yamlStatus, err := aoc.Sync(yamlSpec, &v1.Status{}, Alert, SyncContext{})
+	assert.Equal(t, nil, err)
+	jsonStatus, err := aoc.Sync(jsonSpec, &v1.Status{}, Alert, SyncContext{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, yamlStatus.Hashes.Spec, jsonStatus.Hashes.Spec)
+	assert.Equal(t, 20, len(yamlStatus.Hashes.AppOptics))
+
+	// The resolved services are part of the desired alert
+	jsonSpec.ServiceRefs = []v1.ObjectReference{{Name: "example"}}
+	withService, err := aoc.Sync(jsonSpec, &v1.Status{}, Alert, SyncContext{Services: NewMockLister()})
+	assert.Equal(t, nil, err)
+	assert.NotEqual(t, yamlStatus.Hashes.Spec, withService.Hashes.Spec)
+}
+
 func TestDeletingAlertSuccessSync(t *testing.T) {
 
-	err := aoc.Remove(0, Alert)
+	err := aoc.Remove(&v1.Status{ID: 0}, Alert)
 	if err != nil {
 		t.Errorf("error running TestSpacesSync: %v", err)
 	}
@@ -136,7 +293,7 @@ func TestDeletingAlertSuccessSync(t *testing.T) {
 }
 
 func TestDeletingAlertErrorSync(t *testing.T) {
-	err := aoc.Remove(testInternalServerErrorId, Alert)
+	err := aoc.Remove(&v1.Status{ID: testInternalServerErrorId}, Alert)
 	assert.Equal(t, err.Error(), `{"errors":{"request":["Internal Server Error"]}}`)
 }
 
@@ -270,6 +427,21 @@ func UpdateAlertHandler() http.HandlerFunc {
 	}
 }
 
+func AlertStatusHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ID := mux.Vars(r)["alertId"]
+		if ID == strconv.Itoa(testNotFoundId) {
+			http.Error(w, `{"errors":{"request":["Not Found"]}}`, http.StatusNotFound)
+			return
+		}
+		status := "ok"
+		if ID == strconv.Itoa(testFiringAlertId) {
+			status = "triggered"
+		}
+		w.Write([]byte(`{"alert":{"id":` + ID + `},"status":"` + status + `"}`))
+	}
+}
+
 func DeleteAlertHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
@@ -297,3 +469,16 @@ func AssociateAlertHandler() http.HandlerFunc {
 		w.WriteHeader(http.StatusCreated)
 	}
 }
+
+func ListAlertsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		responseBody := `{
+  "query": {"found": 2, "length": 2, "offset": 0, "total": 2},
+  "alerts": [
+    {"id": 123, "name": "production.web.frontend.response_time"},
+    {"id": 124, "name": "production.web.frontend.errors", "attributes": {"k8s_owner": "this-cluster/web/errors/0b1c"}}
+  ]
+}`
+		w.Write([]byte(responseBody))
+	}
+}
@@ -4,6 +4,7 @@ import (
 	aoApi "github.com/appoptics/appoptics-api-go"
 	"github.com/solarwinds/appoptics-kubernetes-controller/pkg/apis/appoptics-kubernetes-controller/v1"
 	listers "github.com/solarwinds/appoptics-kubernetes-controller/pkg/client/listers/appoptics-kubernetes-controller/v1"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"strings"
 )
 
@@ -13,20 +14,64 @@ type AOResource interface {
 }
 
 type AOResourceCommunicator interface {
-	Sync(v1.TokenAndDataSpec, *v1.Status, string, listers.AppOpticsServiceNamespaceLister) (*v1.Status, error)
-	Remove(int, string) error
+	Sync(v1.TokenAndDataSpec, *v1.Status, string, SyncContext) (*v1.Status, error)
+	Remove(*v1.Status, string) error
+}
+
+// SyncContext holds what a Sync needs to know about the cluster besides the resource itself
+type SyncContext struct {
+	// Namespace is the namespace of the resource being synced
+	Namespace        string
+	Services         listers.AppOpticsServiceLister
+	CompositeMetrics listers.AppOpticsCompositeMetricNamespaceLister
+	// MaintenanceWindows lists the open maintenance windows muting an alert
+	MaintenanceWindows []string
+	// Secrets gets the Secrets service settings can be read from
+	Secrets typedcorev1.SecretInterface
+	// Checkpoint persists the ID of a space, service or alert as soon as it is created, nil to skip
+	Checkpoint Checkpoint
+	// SameAccount checks the services an alert references are in its AppOptics account
+	SameAccount AccountChecker
 }
 
 type AOCommunicator struct {
 	Client aoApi.Client
+	Plan   Plan
+	// Owner is marked on the spaces, services and alerts created, and those marked by another
+	// controller instance are never changed or deleted
+	Owner Ownership
 }
 
-func NewAOCommunicator(token string) AOCommunicator {
-	client := aoApi.NewClient(token)
-	return AOCommunicator{*client}
+// NewAOCommunicator returns a communicator using the token, with the API at apiURL or the client's
+// default when it is empty
+func NewAOCommunicator(token string, apiURL string) AOCommunicator {
+	if apiURL == "" {
+		return AOCommunicator{Client: *aoApi.NewClient(token)}
+	}
+	return AOCommunicator{Client: *aoApi.NewClient(token, aoApi.BaseURLClientOption(apiURL))}
 }
 
-func (aoc *AOCommunicator) Remove(ID int, kind string) error {
+func (aoc *AOCommunicator) Remove(status *v1.Status, kind string) error {
+	ID := status.ID
+	if strings.ToLower(kind) == Metric || strings.ToLower(kind) == CompositeMetric {
+		// Only delete metrics the controller has synced, never one that merely shares the name
+		if status.Name == "" || aoc.Plan.Skip("delete metric %q", status.Name) {
+			return nil
+		}
+		metricsService := NewMetricsService(&aoc.Client)
+		err := metricsService.Delete(status.Name)
+		if err != nil && !CheckIfErrorIsAppOpticsNotFoundError(err, kind, ID) {
+			return err
+		}
+		return nil
+	}
+	owned, err := aoc.owns(kind, ID)
+	if err != nil || !owned {
+		return err
+	}
+	if aoc.Plan.Skip("delete %s %d", strings.ToLower(kind), ID) {
+		return nil
+	}
 	switch strings.ToLower(kind) {
 	case Dashboard:
 		// delete all charts
@@ -42,7 +87,7 @@ func (aoc *AOCommunicator) Remove(ID int, kind string) error {
 			return err
 		}
 	case Alert:
-		alertsService := NewAlertsService(&aoc.Client, nil)
+		alertsService := NewAlertsService(&aoc.Client, nil, "")
 		err := alertsService.Delete(ID)
 		if err != nil && !CheckIfErrorIsAppOpticsNotFoundError(err, kind, ID) {
 			return err
@@ -51,17 +96,47 @@ func (aoc *AOCommunicator) Remove(ID int, kind string) error {
 	return nil
 }
 
-func (aoc *AOCommunicator) Sync(spec v1.TokenAndDataSpec, status *v1.Status, kind string, lister listers.AppOpticsServiceNamespaceLister) (*v1.Status, error) {
+func (aoc *AOCommunicator) Sync(spec v1.TokenAndDataSpec, status *v1.Status, kind string, ctx SyncContext) (*v1.Status, error) {
+	var err error
+	spec.Data, err = ResolveCompositeMetrics(kind, spec.Data, ListerCompositeMetricResolver(ctx.CompositeMetrics))
+	if err != nil {
+		return nil, err
+	}
 	switch strings.ToLower(kind) {
 	case Dashboard:
 		spacesService := NewSpacesService(&aoc.Client)
+		spacesService.plan = &aoc.Plan
+		spacesService.owner = aoc.Owner
+		spacesService.checkpoint = ctx.Checkpoint
 		return spacesService.Sync(spec, status)
 	case Service:
 		servicesService := NewServicesService(&aoc.Client)
+		servicesService.plan = &aoc.Plan
+		servicesService.secrets = ClientSecretKeyResolver(ctx.Secrets)
+		servicesService.owner = aoc.Owner
+		servicesService.checkpoint = ctx.Checkpoint
 		return servicesService.Sync(spec, status)
 	case Alert:
-		alertService := NewAlertsService(&aoc.Client, lister)
+		alertService := NewAlertsService(&aoc.Client, ctx.Services, ctx.Namespace)
+		alertService.plan = &aoc.Plan
+		alertService.maintenanceWindows = ctx.MaintenanceWindows
+		alertService.owner = aoc.Owner
+		alertService.checkpoint = ctx.Checkpoint
+		alertService.sameAccount = ctx.SameAccount
 		return alertService.Sync(spec, status)
+	case Metric:
+		metricsService := NewMetricsService(&aoc.Client)
+		metricsService.plan = &aoc.Plan
+		return metricsService.Sync(spec, status)
+	case CompositeMetric:
+		metricsService := NewMetricsService(&aoc.Client)
+		metricsService.plan = &aoc.Plan
+		return metricsService.SyncComposite(spec, status)
 	}
 	return status, nil
 }
+
+// AlertState reports whether the alert is firing and whether it is active in AppOptics
+func (aoc *AOCommunicator) AlertState(ID int) (firing bool, active bool, err error) {
+	return NewAlertsService(&aoc.Client, nil, "").State(ID)
+}
@@ -0,0 +1,74 @@
+package appoptics
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/solarwinds/appoptics-kubernetes-controller/pkg/apis/appoptics-kubernetes-controller/v1"
+)
+
+// MetricNames returns the names of the metrics used by the chart streams of a dashboard or the
+// conditions of an alert, sorted and without duplicates
+func MetricNames(kind string, data string) ([]string, error) {
+	names := map[string]bool{}
+	switch strings.ToLower(kind) {
+	case Dashboard:
+		dash, err := ParseSpace(data)
+		if err != nil {
+			return nil, err
+		}
+		for _, chart := range dash.Charts {
+			if chart == nil {
+				continue
+			}
+			for _, stream := range chart.Streams {
+				if name := stringValue(stream.Metric); name != "" {
+					names[name] = true
+				}
+			}
+		}
+	case Alert:
+		alert, err := ParseAlert(data)
+		if err != nil {
+			return nil, err
+		}
+		for _, condition := range alert.Conditions {
+			if name := stringValue(condition.MetricName); name != "" {
+				names[name] = true
+			}
+		}
+	}
+
+	var sorted []string
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted, nil
+}
+
+// UnknownMetrics returns the metrics used by a dashboard or alert that do not exist in the AppOptics
+// account, which usually means a typo that leaves charts empty and alerts unable to fire
+func (aoc *AOCommunicator) UnknownMetrics(spec v1.TokenAndDataSpec, kind string, ctx SyncContext) ([]string, error) {
+	data, err := ResolveCompositeMetrics(kind, spec.Data, ListerCompositeMetricResolver(ctx.CompositeMetrics))
+	if err != nil {
+		return nil, err
+	}
+	names, err := MetricNames(kind, data)
+	if err != nil {
+		return nil, err
+	}
+
+	metricsService := NewMetricsService(&aoc.Client)
+	var unknown []string
+	for _, name := range names {
+		_, err := metricsService.Retrieve(name)
+		if err != nil {
+			if !CheckIfErrorIsAppOpticsNotFoundError(err, Metric, 0) {
+				return nil, err
+			}
+			unknown = append(unknown, name)
+		}
+	}
+	return unknown, nil
+}
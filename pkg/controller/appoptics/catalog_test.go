@@ -0,0 +1,51 @@
+package appoptics
+
+import (
+	"testing"
+
+	"github.com/solarwinds/appoptics-kubernetes-controller/pkg/apis/appoptics-kubernetes-controller/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDashboardMetricNames(t *testing.T) {
+	data := `
+name: test
+charts:
+- name: cpu
+  streams:
+  - metric: cpu.percent
+  - metric: memory.percent
+  - composite: 's("cpu.percent", "*")'
+- name: memory
+  streams:
+  - metric: memory.percent
+`
+	names, err := MetricNames(Dashboard, data)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"cpu.percent", "memory.percent"}, names)
+}
+
+func TestAlertUnknownMetrics(t *testing.T) {
+	data := `
+name: test
+conditions:
+- type: above
+  metric_name: ` + testMetricName + `
+- type: absent
+  metric_name: ` + testUnknownMetricName + `
+`
+	unknown, err := aoc.UnknownMetrics(v1.TokenAndDataSpec{Data: data}, Alert, SyncContext{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{testUnknownMetricName}, unknown)
+}
+
+func TestUnknownMetricsError(t *testing.T) {
+	data := `
+name: test
+conditions:
+- type: above
+  metric_name: ` + testInternalMetricName + `
+`
+	_, err := aoc.UnknownMetrics(v1.TokenAndDataSpec{Data: data}, Alert, SyncContext{})
+	assert.NotEqual(t, nil, err)
+}
@@ -7,10 +7,11 @@ import (
 type ChartsService struct {
 	aoApi.ChartsCommunicator
 	client *aoApi.Client
+	plan   *Plan
 }
 
 func NewChartsService(c *aoApi.Client) *ChartsService {
-	return &ChartsService{aoApi.NewChartsService(c), c}
+	return &ChartsService{aoApi.NewChartsService(c), c, nil}
 }
 
 func (chrt *ChartsService) DeleteAll(charts []*aoApi.Chart, spaceID int) error {
@@ -25,6 +26,9 @@ func (chrt *ChartsService) DeleteAll(charts []*aoApi.Chart, spaceID int) error {
 }
 
 func (chrt *ChartsService) syncCharts(dashCharts []*aoApi.Chart, spaceID int) error {
+	if chrt.plan.Skip("replace the charts of dashboard %d with %d charts", spaceID, len(dashCharts)) {
+		return nil
+	}
 	aoCharts, err := chrt.List(spaceID)
 	if err != nil {
 		return err
@@ -0,0 +1,14 @@
+package appoptics
+
+// Checkpoint persists the ID of a space, service or alert as soon as it is created or adopted, before
+// the steps that follow such as creating charts or associating services. A sync that fails or is
+// stopped part way then resumes with the object instead of leaking it.
+type Checkpoint func(ID int) error
+
+// save calls the checkpoint, a nil Checkpoint saves nothing
+func (c Checkpoint) save(ID int) error {
+	if c == nil {
+		return nil
+	}
+	return c(ID)
+}
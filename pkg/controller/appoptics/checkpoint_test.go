@@ -0,0 +1,54 @@
+package appoptics
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/solarwinds/appoptics-kubernetes-controller/pkg/apis/appoptics-kubernetes-controller/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSpaceIsCheckpointed(t *testing.T) {
+	var saved []int
+	checkpoint := func(ID int) error {
+		saved = append(saved, ID)
+		return nil
+	}
+	td := v1.TokenAndDataSpec{Namespace: "Default", Data: "name: DevOps Alerts", Secret: "blah"}
+
+	ts, err := aoc.Sync(td, &v1.Status{}, Dashboard, SyncContext{Checkpoint: checkpoint})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []int{ts.ID}, saved)
+}
+
+func TestNewAlertIsCheckpointedBeforeServices(t *testing.T) {
+	var saved *v1.Status
+	status := &v1.Status{}
+	checkpoint := func(ID int) error {
+		// Services are associated and the hashes taken only once the ID is stored
+		saved = status.DeepCopy()
+		assert.Equal(t, ID, status.ID)
+		return nil
+	}
+	alertSpec := v1.TokenAndDataSpec{Namespace: "Default", Data: "name: newAlert", ServiceRefs: []v1.ObjectReference{
+		{Name: "example"},
+	}}
+
+	ts, err := aoc.Sync(alertSpec, status, Alert, SyncContext{Namespace: "default", Services: NewMockLister(), Checkpoint: checkpoint})
+	assert.Equal(t, nil, err)
+	assert.NotEqual(t, nil, saved)
+	assert.Equal(t, ts.ID, saved.ID)
+	assert.Equal(t, 0, len(saved.Hashes.Spec))
+}
+
+func TestCheckpointErrorFailsSync(t *testing.T) {
+	checkpoint := func(ID int) error {
+		return errors.New("conflict")
+	}
+	td := v1.TokenAndDataSpec{Namespace: "Default", Data: "name: DevOps Alerts", Secret: "blah"}
+
+	_, err := aoc.Sync(td, &v1.Status{}, Dashboard, SyncContext{Checkpoint: checkpoint})
+	assert.NotEqual(t, nil, err)
+	// The object exists in AppOptics, so the sync is retried rather than marked invalid
+	assert.False(t, IsPermanentError(err))
+}
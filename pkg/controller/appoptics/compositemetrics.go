@@ -0,0 +1,180 @@
+package appoptics
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	aoApi "github.com/appoptics/appoptics-api-go"
+	"github.com/ghodss/yaml"
+	listers "github.com/solarwinds/appoptics-kubernetes-controller/pkg/client/listers/appoptics-kubernetes-controller/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+// CompositeMetricReference is the field dashboard chart streams and alert conditions use to name an
+// AppOpticsCompositeMetric instead of a metric
+const CompositeMetricReference = "composite_metric"
+
+// CompositeMetricResolver returns the AppOptics metric name of the AppOpticsCompositeMetric with the
+// given resource name
+type CompositeMetricResolver func(name string) (string, error)
+
+// ListerCompositeMetricResolver resolves composite metrics from the resources in a namespace. A
+// composite metric that does not exist or has not been synced yet is an error, so the referencing
+// resource is retried later.
+func ListerCompositeMetricResolver(lister listers.AppOpticsCompositeMetricNamespaceLister) CompositeMetricResolver {
+	return func(name string) (string, error) {
+		if lister == nil {
+			return "", fmt.Errorf("AppOpticsCompositeMetric %s can not be resolved", name)
+		}
+		compositeMetric, err := lister.Get(name)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				return "", fmt.Errorf("AppOpticsCompositeMetric %s does not exist", name)
+			}
+			return "", err
+		}
+		if compositeMetric.Status.Name == "" {
+			return "", fmt.Errorf("AppOpticsCompositeMetric %s has not been synced to AppOptics yet", name)
+		}
+		return compositeMetric.Status.Name, nil
+	}
+}
+
+// ParseCompositeMetric decodes the spec.data of an AppOpticsCompositeMetric and checks its expression
+func ParseCompositeMetric(data string) (aoApi.Metric, error) {
+	metric, err := ParseMetric(data)
+	if err != nil {
+		return metric, err
+	}
+	if metric.Type == "" {
+		metric.Type = "composite"
+	}
+	if metric.Type != "composite" {
+		return metric, NewPermanentError(fmt.Errorf("type must be composite, not %q", metric.Type))
+	}
+	err = ValidateCompositeExpression(metric.Composite)
+	if err != nil {
+		return metric, NewPermanentError(err)
+	}
+	return metric, nil
+}
+
+// ValidateCompositeExpression catches the mistakes in a composite metric expression that can be
+// found without AppOptics: it must be a function call with balanced brackets and closed strings.
+// AppOptics validates the rest when the metric is saved.
+func ValidateCompositeExpression(expression string) error {
+	expression = strings.TrimSpace(expression)
+	if expression == "" {
+		return fmt.Errorf("composite is required")
+	}
+	open := strings.Index(expression, "(")
+	if open <= 0 || strings.TrimLeft(expression[:open], "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ_") != "" {
+		return fmt.Errorf("composite must start with a function call like s(...)")
+	}
+
+	closing := map[rune]rune{')': '(', ']': '[', '}': '{'}
+	var stack []rune
+	var quote rune
+	for i, r := range expression {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '(' || r == '[' || r == '{':
+			stack = append(stack, r)
+		case closing[r] != 0:
+			if len(stack) == 0 || stack[len(stack)-1] != closing[r] {
+				return fmt.Errorf("composite has an unexpected %q at position %d", r, i)
+			}
+			stack = stack[:len(stack)-1]
+		}
+	}
+	if quote != 0 {
+		return fmt.Errorf("composite has an unterminated string")
+	}
+	if len(stack) != 0 {
+		return fmt.Errorf("composite has an unclosed %q", stack[len(stack)-1])
+	}
+	return nil
+}
+
+// ResolveCompositeMetrics replaces the composite_metric references in the spec.data of a dashboard or
+// alert with the name of the metric they resolve to
+func ResolveCompositeMetrics(kind string, data string, resolve CompositeMetricResolver) (string, error) {
+	var doc map[string]interface{}
+	err := yaml.Unmarshal([]byte(data), &doc)
+	if err != nil {
+		return "", NewPermanentError(err)
+	}
+
+	resolved := false
+	replace := func(item interface{}, metricField string) error {
+		fields, ok := item.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		name, ok := fields[CompositeMetricReference]
+		if !ok {
+			return nil
+		}
+		nameStr, ok := name.(string)
+		if !ok {
+			return NewPermanentError(fmt.Errorf("%s must be the name of an AppOpticsCompositeMetric", CompositeMetricReference))
+		}
+		metricName, err := resolve(nameStr)
+		if err != nil {
+			return err
+		}
+		delete(fields, CompositeMetricReference)
+		fields[metricField] = metricName
+		resolved = true
+		return nil
+	}
+
+	switch strings.ToLower(kind) {
+	case Dashboard:
+		for _, chart := range listField(doc, "charts") {
+			chartFields, _ := chart.(map[string]interface{})
+			for _, stream := range listField(chartFields, "streams") {
+				if err := replace(stream, "metric"); err != nil {
+					return "", err
+				}
+			}
+		}
+	case Alert:
+		for _, condition := range listField(doc, "conditions") {
+			if err := replace(condition, "metric_name"); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	if !resolved {
+		return data, nil
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// CompositeMetricNames returns the AppOpticsCompositeMetric names referenced by the spec.data of a
+// dashboard or alert
+func CompositeMetricNames(kind string, data string) ([]string, error) {
+	var names []string
+	_, err := ResolveCompositeMetrics(kind, data, func(name string) (string, error) {
+		names = append(names, name)
+		return name, nil
+	})
+	return names, err
+}
+
+func listField(fields map[string]interface{}, key string) []interface{} {
+	list, _ := fields[key].([]interface{})
+	return list
+}
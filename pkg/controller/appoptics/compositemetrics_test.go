@@ -0,0 +1,109 @@
+package appoptics
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/solarwinds/appoptics-kubernetes-controller/pkg/apis/appoptics-kubernetes-controller/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func testResolver(name string) (string, error) {
+	if name == "missing" {
+		return "", fmt.Errorf("AppOpticsCompositeMetric %s does not exist", name)
+	}
+	return "composite." + name, nil
+}
+
+func TestValidateCompositeExpression(t *testing.T) {
+	assert.Equal(t, nil, ValidateCompositeExpression(`sum(s("cpu.percent", {"host": "*"}))`))
+	assert.Equal(t, nil, ValidateCompositeExpression(`divide([s("a", "*"), s("b", "*")])`))
+	assert.NotEqual(t, nil, ValidateCompositeExpression(``))
+	assert.NotEqual(t, nil, ValidateCompositeExpression(`"cpu.percent"`))
+	assert.NotEqual(t, nil, ValidateCompositeExpression(`sum(s("cpu.percent", {"host": "*"})`))
+	assert.NotEqual(t, nil, ValidateCompositeExpression(`sum(s("cpu.percent", {"host": "*"]))`))
+	assert.NotEqual(t, nil, ValidateCompositeExpression(`s("cpu.percent, "*")`))
+}
+
+func TestParseCompositeMetricDefaultsType(t *testing.T) {
+	metric, err := ParseCompositeMetric(`
+name: cpu.total
+composite: sum(s("cpu.percent", "*"))
+`)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "composite", metric.Type)
+}
+
+func TestParseCompositeMetricRejectsGauges(t *testing.T) {
+	_, err := ParseCompositeMetric(`
+name: cpu.total
+type: gauge
+composite: sum(s("cpu.percent", "*"))
+`)
+	assert.True(t, IsPermanentError(err))
+}
+
+func TestResolveCompositeMetricsInDashboard(t *testing.T) {
+	data := `
+name: Test
+charts:
+- name: CPU
+  streams:
+  - composite_metric: cpu-total
+  - metric: memory.percent
+`
+	resolved, err := ResolveCompositeMetrics(Dashboard, data, testResolver)
+	assert.Equal(t, nil, err)
+
+	dash, err := ParseSpace(resolved)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "composite.cpu-total", *dash.Charts[0].Streams[0].Metric)
+	assert.Equal(t, "memory.percent", *dash.Charts[0].Streams[1].Metric)
+}
+
+func TestResolveCompositeMetricsInAlert(t *testing.T) {
+	data := `
+name: Test
+conditions:
+- type: above
+  composite_metric: cpu-total
+  threshold: 90
+`
+	resolved, err := ResolveCompositeMetrics(Alert, data, testResolver)
+	assert.Equal(t, nil, err)
+
+	alert, err := ParseAlert(resolved)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "composite.cpu-total", *alert.Conditions[0].MetricName)
+}
+
+func TestResolveMissingCompositeMetric(t *testing.T) {
+	data := `
+name: Test
+conditions:
+- type: above
+  composite_metric: missing
+`
+	_, err := ResolveCompositeMetrics(Alert, data, testResolver)
+	assert.NotEqual(t, nil, err)
+	assert.False(t, IsPermanentError(err))
+}
+
+func TestUnsyncedCompositeMetricFailsAlertSync(t *testing.T) {
+	data := `
+name: Test
+conditions:
+- type: above
+  composite_metric: cpu-total
+`
+	alertSpec := v1.TokenAndDataSpec{Namespace: "Default", Data: data, Secret: ""}
+	_, err := aoc.Sync(alertSpec, &v1.Status{}, Alert, SyncContext{Services: NewMockLister()})
+	assert.NotEqual(t, nil, err)
+}
+
+func TestDataWithoutReferencesIsUnchanged(t *testing.T) {
+	data := `name: Test`
+	resolved, err := ResolveCompositeMetrics(Dashboard, data, testResolver)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, data, resolved)
+}
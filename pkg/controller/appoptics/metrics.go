@@ -0,0 +1,98 @@
+package appoptics
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	aoApi "github.com/appoptics/appoptics-api-go"
+	"github.com/ghodss/yaml"
+	"github.com/solarwinds/appoptics-kubernetes-controller/pkg/apis/appoptics-kubernetes-controller/v1"
+)
+
+type MetricsService struct {
+	aoApi.MetricsCommunicator
+	client *aoApi.Client
+	plan   *Plan
+}
+
+func NewMetricsService(c *aoApi.Client) *MetricsService {
+	return &MetricsService{c.MetricsService(), c, nil}
+}
+
+// Sync makes the AppOptics metric match the spec. Metrics are keyed by name rather than ID, so the
+// name is kept in status.Name and a PUT both creates and updates them.
+func (ms *MetricsService) Sync(spec v1.TokenAndDataSpec, status *v1.Status) (*v1.Status, error) {
+	metric, err := ParseMetric(spec.Data)
+	if err != nil {
+		return nil, err
+	}
+	return ms.sync(metric, status)
+}
+
+// SyncComposite makes the AppOptics composite metric match the spec, the same way Sync does
+func (ms *MetricsService) SyncComposite(spec v1.TokenAndDataSpec, status *v1.Status) (*v1.Status, error) {
+	metric, err := ParseCompositeMetric(spec.Data)
+	if err != nil {
+		return nil, err
+	}
+	return ms.sync(metric, status)
+}
+
+func (ms *MetricsService) sync(metric aoApi.Metric, status *v1.Status) (*v1.Status, error) {
+	specHash, err := Hash(metric)
+	if err != nil {
+		return nil, err
+	}
+
+	var aoHash []byte
+	aoMetric, err := ms.Retrieve(metric.Name)
+	if err != nil {
+		if !CheckIfErrorIsAppOpticsNotFoundError(err, Metric, status.ID) {
+			return nil, err
+		}
+	} else {
+		aoHash, err = Hash(aoMetric)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Local vs Remote are different so update AO
+	if aoMetric == nil || status.Name != metric.Name || bytes.Compare(status.Hashes.Spec, specHash) != 0 || bytes.Compare(status.Hashes.AppOptics, aoHash) != 0 {
+		if ms.plan.Skip("update metric %q", metric.Name) {
+			return status, nil
+		}
+		err = ms.Update(&metric)
+		if err != nil {
+			return nil, err
+		}
+
+		aoMetric, err = ms.Retrieve(metric.Name)
+		if err != nil {
+			return nil, err
+		}
+		status.Hashes.AppOptics, err = Hash(aoMetric)
+		if err != nil {
+			return nil, err
+		}
+		status.Name = metric.Name
+		status.Hashes.Spec = specHash
+		status.UpdatedAt = int(time.Now().Unix())
+	}
+
+	return status, nil
+}
+
+// ParseMetric decodes the spec.data of an AppOpticsMetric
+func ParseMetric(data string) (aoApi.Metric, error) {
+	var metric aoApi.Metric
+	err := yaml.Unmarshal([]byte(data), &metric)
+	if err != nil {
+		return metric, NewPermanentError(err)
+	}
+	if metric.Name == "" {
+		return metric, NewPermanentError(fmt.Errorf("name is required"))
+	}
+	return metric, nil
+}
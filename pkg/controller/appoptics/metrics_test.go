@@ -0,0 +1,150 @@
+package appoptics
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+
+	aoApi "github.com/appoptics/appoptics-api-go"
+	"github.com/gorilla/mux"
+	"github.com/solarwinds/appoptics-kubernetes-controller/pkg/apis/appoptics-kubernetes-controller/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testMetricName         = "kafka.controller.KafkaController.ActiveControllerCount"
+	testMissingMetricName  = "missing.metric"
+	testInvalidMetricName  = "invalid.metric"
+	testInternalMetricName = "error.metric"
+	testUnknownMetricName  = "unknown.metric"
+)
+
+// createdMetrics remembers the metrics PUT to the test server, so they can be retrieved afterwards
+var createdMetrics = struct {
+	sync.Mutex
+	names map[string]bool
+}{names: map[string]bool{}}
+
+func TestNewMetricSyncSuccess(t *testing.T) {
+	data := `
+name: ` + testMissingMetricName + `
+type: gauge
+display_name: Missing
+`
+	td := v1.TokenAndDataSpec{Namespace: "Default", Data: data, Secret: "blah"}
+
+	ts, err := aoc.Sync(td, &v1.Status{}, Metric, SyncContext{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, testMissingMetricName, ts.Name)
+	assert.NotEqual(t, 0, len(ts.Hashes.Spec))
+	assert.NotEqual(t, 0, len(ts.Hashes.AppOptics))
+}
+
+func TestExistingMetricSyncSuccess(t *testing.T) {
+	data := `
+name: ` + testMetricName + `
+type: gauge
+attributes:
+  display_units_short: count
+`
+	td := v1.TokenAndDataSpec{Namespace: "Default", Data: data, Secret: "blah"}
+
+	ts, err := aoc.Sync(td, &v1.Status{}, Metric, SyncContext{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, testMetricName, ts.Name)
+
+	// Nothing changed on either side so the second sync must not touch the status
+	updatedAt := ts.UpdatedAt
+	ts.UpdatedAt = 0
+	ts, err = aoc.Sync(td, ts, Metric, SyncContext{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, ts.UpdatedAt)
+	assert.NotEqual(t, 0, updatedAt)
+}
+
+func TestMetricSyncValidationError(t *testing.T) {
+	data := `
+name: ` + testInvalidMetricName + `
+type: gauge
+`
+	td := v1.TokenAndDataSpec{Namespace: "Default", Data: data, Secret: "blah"}
+
+	_, err := aoc.Sync(td, &v1.Status{}, Metric, SyncContext{})
+	assert.NotEqual(t, nil, err)
+	assert.True(t, IsPermanentError(err))
+}
+
+func TestMetricWithoutNameIsPermanentError(t *testing.T) {
+	td := v1.TokenAndDataSpec{Namespace: "Default", Data: `type: gauge`, Secret: "blah"}
+
+	_, err := aoc.Sync(td, &v1.Status{}, Metric, SyncContext{})
+	assert.True(t, IsPermanentError(err))
+}
+
+func TestDeletingUnsyncedMetricDoesNothing(t *testing.T) {
+	err := aoc.Remove(&v1.Status{}, Metric)
+	assert.Equal(t, nil, err)
+}
+
+func TestDeletingMetricErrorSync(t *testing.T) {
+	err := aoc.Remove(&v1.Status{Name: testInternalMetricName}, Metric)
+	assert.Equal(t, `{"errors":{"request":["Internal Server Error"]}}`, err.Error())
+}
+
+func RetrieveMetricHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["name"]
+		createdMetrics.Lock()
+		created := createdMetrics.names[name]
+		createdMetrics.Unlock()
+		if (name == testMissingMetricName && !created) || name == testUnknownMetricName {
+			http.Error(w, `{"errors":{"request":["Not Found"]}}`, http.StatusNotFound)
+			return
+		}
+		if name == testInternalMetricName {
+			http.Error(w, `{"errors":{"request":["Internal Server Error"]}}`, http.StatusInternalServerError)
+			return
+		}
+		responseBody := `{
+  "name": "` + name + `",
+  "display_name": null,
+  "type": "gauge",
+  "attributes": {
+    "display_units_short": "count",
+    "aggregate": false
+  },
+  "description": null,
+  "period": 60
+}`
+		w.Write([]byte(responseBody))
+	}
+}
+
+func UpdateMetricHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var metric aoApi.Metric
+		err := JsonValidateAndDecode(r.Body, &metric)
+		if err != nil {
+			http.Error(w, `{"errors":{"request":["Malformed Data"]}}`, http.StatusInternalServerError)
+			return
+		}
+		if metric.Name == testInvalidMetricName {
+			http.Error(w, `{"errors":{"params":{"type":["is invalid"]}}}`, http.StatusBadRequest)
+			return
+		}
+		createdMetrics.Lock()
+		createdMetrics.names[mux.Vars(r)["name"]] = true
+		createdMetrics.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func DeleteMetricHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if mux.Vars(r)["name"] == testInternalMetricName {
+			http.Error(w, `{"errors":{"request":["Internal Server Error"]}}`, http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
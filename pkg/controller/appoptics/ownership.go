@@ -0,0 +1,200 @@
+package appoptics
+
+import (
+	"fmt"
+	"strings"
+
+	aoApi "github.com/appoptics/appoptics-api-go"
+	"github.com/golang/glog"
+)
+
+const (
+	// OwnerAttribute is the alert attribute holding the ownership marker of an alert
+	OwnerAttribute = "k8s_owner"
+
+	// markedNamePrefix and markedNameSuffix enclose the ownership marker appended to space names and
+	// service titles, which have no room for anything else
+	markedNamePrefix = " [k8s:"
+	markedNameSuffix = "]"
+)
+
+// Ownership identifies the controller instance, by the ID of its cluster, and the custom resource an
+// AppOptics object was created for. The zero Ownership marks nothing and owns everything.
+type Ownership struct {
+	ClusterID string
+	Namespace string
+	Name      string
+	UID       string
+}
+
+// IsZero reports whether the ownership is unset
+func (o Ownership) IsZero() bool {
+	return o.ClusterID == ""
+}
+
+// Marker encodes the ownership as "cluster/namespace/name/uid"
+func (o Ownership) Marker() string {
+	return strings.Join([]string{o.ClusterID, o.Namespace, o.Name, o.UID}, "/")
+}
+
+// ParseMarker decodes a marker made by Marker
+func ParseMarker(marker string) (Ownership, bool) {
+	parts := strings.Split(marker, "/")
+	if len(parts) != 4 || parts[0] == "" {
+		return Ownership{}, false
+	}
+	return Ownership{ClusterID: parts[0], Namespace: parts[1], Name: parts[2], UID: parts[3]}, true
+}
+
+// MarkName appends the cluster, namespace and name of the ownership to a space name or service title
+func (o Ownership) MarkName(name string) string {
+	if o.IsZero() {
+		return name
+	}
+	return name + markedNamePrefix + o.ClusterID + "/" + o.Namespace + "/" + o.Name + markedNameSuffix
+}
+
+// ParseMarkedName splits a space name or service title made by MarkName into the name and its
+// ownership, which has no UID. Names without a marker are returned as they are.
+func ParseMarkedName(marked string) (string, Ownership, bool) {
+	start := strings.LastIndex(marked, markedNamePrefix)
+	if start == -1 || !strings.HasSuffix(marked, markedNameSuffix) {
+		return marked, Ownership{}, false
+	}
+	parts := strings.Split(marked[start+len(markedNamePrefix):len(marked)-len(markedNameSuffix)], "/")
+	if len(parts) != 3 || parts[0] == "" {
+		return marked, Ownership{}, false
+	}
+	return marked[:start], Ownership{ClusterID: parts[0], Namespace: parts[1], Name: parts[2]}, true
+}
+
+// UnmarkedName returns a space name or service title without its ownership marker
+func UnmarkedName(marked string) string {
+	name, _, _ := ParseMarkedName(marked)
+	return name
+}
+
+// MarkAlert stores the ownership marker in the alert's attributes, leaving the spec's map untouched
+func (o Ownership) MarkAlert(alert *aoApi.Alert) {
+	if o.IsZero() {
+		return
+	}
+	attributes := map[string]interface{}{}
+	for key, value := range alert.Attributes {
+		attributes[key] = value
+	}
+	attributes[OwnerAttribute] = o.Marker()
+	alert.Attributes = attributes
+}
+
+// AlertOwnership returns the ownership marked in an alert's attributes
+func AlertOwnership(alert *aoApi.Alert) (Ownership, bool) {
+	marker, ok := alert.Attributes[OwnerAttribute].(string)
+	if !ok {
+		return Ownership{}, false
+	}
+	return ParseMarker(marker)
+}
+
+// checkOwner refuses to change an AppOptics object marked as owned by another custom resource or
+// controller instance. Unmarked objects, such as those created before markers were added, are adopted.
+func (o Ownership) checkOwner(kind string, ID int, owner Ownership, marked bool) error {
+	if o.IsZero() || !marked || o.sameResource(owner) {
+		return nil
+	}
+	return NewPermanentError(fmt.Errorf("%s %d is owned by %s/%s in cluster %s, not changing it", kind, ID, owner.Namespace, owner.Name, owner.ClusterID))
+}
+
+// sameResource reports whether the ownerships are of the same custom resource and controller
+// instance. UIDs are not compared, space names and service titles carry none.
+func (o Ownership) sameResource(owner Ownership) bool {
+	return o.ClusterID == owner.ClusterID && o.Namespace == owner.Namespace && o.Name == owner.Name
+}
+
+// owns reports whether the communicator may delete the space, service or alert. Objects marked as
+// owned by another controller instance are left in place, so deleting the custom resource still
+// completes.
+func (aoc *AOCommunicator) owns(kind string, ID int) (bool, error) {
+	if aoc.Owner.IsZero() || ID == 0 {
+		return true, nil
+	}
+	var owner Ownership
+	var marked bool
+	var err error
+	switch strings.ToLower(kind) {
+	case Dashboard:
+		var space *aoApi.Space
+		space, err = NewSpacesService(&aoc.Client).Retrieve(ID)
+		if err == nil {
+			_, owner, marked = ParseMarkedName(space.Name)
+		}
+	case Service:
+		var service *aoApi.Service
+		service, err = NewServicesService(&aoc.Client).Retrieve(ID)
+		if err == nil {
+			_, owner, marked = ParseMarkedName(stringValue(service.Title))
+		}
+	case Alert:
+		var alert *aoApi.Alert
+		alert, err = NewAlertsService(&aoc.Client, nil, "").Retrieve(ID)
+		if err == nil {
+			owner, marked = AlertOwnership(alert)
+		}
+	}
+	if err != nil {
+		if CheckIfErrorIsAppOpticsNotFoundError(err, kind, ID) {
+			return true, nil
+		}
+		return false, err
+	}
+	if err := aoc.Owner.checkOwner(kind, ID, owner, marked); err != nil {
+		glog.Warningf("Not deleting: %s", err.Error())
+		return false, nil
+	}
+	return true, nil
+}
+
+// OwnedObject is a space, service or alert marked as owned by a controller instance
+type OwnedObject struct {
+	// Kind is Dashboard, Service or Alert
+	Kind string
+	ID   int
+	// Name is the space name, service title or alert name without the marker
+	Name  string
+	Owner Ownership
+}
+
+// OwnedObjects lists the spaces, services and alerts of the account marked with the cluster ID
+func (aoc *AOCommunicator) OwnedObjects(clusterID string) ([]OwnedObject, error) {
+	var owned []OwnedObject
+	spaces, err := NewSpacesService(&aoc.Client).List(nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, space := range spaces {
+		if name, owner, ok := ParseMarkedName(space.Name); ok && owner.ClusterID == clusterID {
+			owned = append(owned, OwnedObject{Kind: Dashboard, ID: space.ID, Name: name, Owner: owner})
+		}
+	}
+
+	services, err := NewServicesService(&aoc.Client).List()
+	if err != nil {
+		return nil, err
+	}
+	for _, service := range services.Services {
+		if name, owner, ok := ParseMarkedName(stringValue(service.Title)); ok && owner.ClusterID == clusterID && service.ID != nil {
+			owned = append(owned, OwnedObject{Kind: Service, ID: *service.ID, Name: name, Owner: owner})
+		}
+	}
+
+	alerts, err := NewAlertsService(&aoc.Client, nil, "").List()
+	if err != nil {
+		return nil, err
+	}
+	for _, alert := range alerts.Alerts {
+		if owner, ok := AlertOwnership(alert); ok && owner.ClusterID == clusterID && alert.ID != nil {
+			owned = append(owned, OwnedObject{Kind: Alert, ID: *alert.ID, Name: stringValue(alert.Name), Owner: owner})
+		}
+	}
+	return owned, nil
+}
@@ -0,0 +1,79 @@
+package appoptics
+
+import (
+	"testing"
+
+	aoApi "github.com/appoptics/appoptics-api-go"
+	"github.com/solarwinds/appoptics-kubernetes-controller/pkg/apis/appoptics-kubernetes-controller/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+var testOwner = Ownership{ClusterID: "this-cluster", Namespace: "default", Name: "cpus", UID: "0b1c"}
+
+func TestMarkedName(t *testing.T) {
+	marked := testOwner.MarkName("CPUs [prod]")
+	assert.Equal(t, "CPUs [prod] [k8s:this-cluster/default/cpus]", marked)
+
+	name, owner, ok := ParseMarkedName(marked)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "CPUs [prod]", name)
+	assert.Equal(t, Ownership{ClusterID: "this-cluster", Namespace: "default", Name: "cpus"}, owner)
+
+	name, _, ok = ParseMarkedName("CPUs [prod]")
+	assert.Equal(t, false, ok)
+	assert.Equal(t, "CPUs [prod]", name)
+
+	// Nothing is marked without a cluster ID
+	assert.Equal(t, "CPUs", Ownership{}.MarkName("CPUs"))
+}
+
+func TestMarkAlert(t *testing.T) {
+	attributes := map[string]interface{}{"runbook_url": "http://example.com"}
+	alert := aoApi.Alert{Attributes: attributes}
+	testOwner.MarkAlert(&alert)
+
+	owner, ok := AlertOwnership(&alert)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, testOwner, owner)
+	assert.Equal(t, "http://example.com", alert.Attributes["runbook_url"])
+	// The parsed spec is left as it is
+	assert.Equal(t, 1, len(attributes))
+}
+
+func TestCheckOwner(t *testing.T) {
+	assert.Equal(t, nil, testOwner.checkOwner(Dashboard, 1, Ownership{ClusterID: "this-cluster", Namespace: "default", Name: "cpus"}, true))
+	assert.Equal(t, nil, testOwner.checkOwner(Dashboard, 1, Ownership{}, false))
+	assert.True(t, IsPermanentError(testOwner.checkOwner(Dashboard, 1, Ownership{ClusterID: "this-cluster", Namespace: "default", Name: "memory"}, true)))
+	assert.True(t, IsPermanentError(testOwner.checkOwner(Dashboard, 1, Ownership{ClusterID: "other-cluster", Namespace: "default", Name: "cpus"}, true)))
+}
+
+func TestSyncForeignOwnedSpace(t *testing.T) {
+	owned := &AOCommunicator{Client: *client, Owner: testOwner}
+	td := v1.TokenAndDataSpec{Namespace: "default", Data: "name: CPUs", Secret: "blah"}
+
+	_, err := owned.Sync(td, &v1.Status{ID: testForeignOwnedId}, Dashboard, SyncContext{})
+	assert.Equal(t, true, IsPermanentError(err))
+}
+
+func TestRemoveForeignOwnedSpace(t *testing.T) {
+	owned := &AOCommunicator{Client: *client, Plan: Plan{DryRun: true}, Owner: testOwner}
+
+	err := owned.Remove(&v1.Status{ID: testForeignOwnedId}, Dashboard)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(owned.Plan.Changes))
+
+	// Unmarked spaces are adopted
+	err = owned.Remove(&v1.Status{ID: 1}, Dashboard)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"delete dashboard 1"}, owned.Plan.Changes)
+}
+
+func TestOwnedObjects(t *testing.T) {
+	owned, err := aoc.OwnedObjects("this-cluster")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []OwnedObject{
+		{Kind: Dashboard, ID: 5, Name: "CPUs", Owner: Ownership{ClusterID: "this-cluster", Namespace: "default", Name: "cpus"}},
+		{Kind: Service, ID: 146, Name: "Notify Ops Room", Owner: Ownership{ClusterID: "this-cluster", Namespace: "monitoring", Name: "ops"}},
+		{Kind: Alert, ID: 124, Name: "production.web.frontend.errors", Owner: Ownership{ClusterID: "this-cluster", Namespace: "web", Name: "errors", UID: "0b1c"}},
+	}, owned)
+}
@@ -0,0 +1,25 @@
+package appoptics
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+)
+
+// Plan collects the changes a dry run would have made in AppOptics instead of making them
+type Plan struct {
+	DryRun  bool
+	Changes []string
+}
+
+// Skip records the change and reports true when running dry, in which case the caller must
+// leave AppOptics untouched. A nil Plan never skips anything.
+func (p *Plan) Skip(format string, args ...interface{}) bool {
+	if p == nil || !p.DryRun {
+		return false
+	}
+	change := fmt.Sprintf(format, args...)
+	glog.Infof("Dry run, not applying: %s", change)
+	p.Changes = append(p.Changes, change)
+	return true
+}
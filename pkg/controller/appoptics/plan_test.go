@@ -0,0 +1,51 @@
+package appoptics
+
+import (
+	"testing"
+
+	"github.com/solarwinds/appoptics-kubernetes-controller/pkg/apis/appoptics-kubernetes-controller/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDryRunNewAlertIsOnlyPlanned(t *testing.T) {
+	data := `
+    {
+     "name": "` + errorName + `"
+	}`
+	dryRun := &AOCommunicator{Client: *client, Plan: Plan{DryRun: true}}
+	alertSpec := v1.TokenAndDataSpec{Namespace: "Default", Data: data, Secret: ""}
+
+	// The test server fails to create an alert with this name, so any real call would error
+	ts, err := dryRun.Sync(alertSpec, &v1.Status{ID: 0}, Alert, SyncContext{Services: NewMockLister()})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, ts.ID)
+	assert.Equal(t, []string{`create alert "Error" with 0 services`}, dryRun.Plan.Changes)
+}
+
+func TestDryRunNewSpaceIsOnlyPlanned(t *testing.T) {
+	data := `
+---
+name: ` + spaceError + `
+charts:
+`
+	dryRun := &AOCommunicator{Client: *client, Plan: Plan{DryRun: true}}
+	td := v1.TokenAndDataSpec{Namespace: "Default", Data: data, Secret: "blah"}
+
+	ts, err := dryRun.Sync(td, &v1.Status{ID: 0}, Dashboard, SyncContext{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, ts.ID)
+	assert.Equal(t, []string{`create dashboard "spaceError"`, "replace the charts of dashboard 0 with 0 charts"}, dryRun.Plan.Changes)
+}
+
+func TestDryRunRemoveIsOnlyPlanned(t *testing.T) {
+	dryRun := &AOCommunicator{Client: *client, Plan: Plan{DryRun: true}}
+
+	err := dryRun.Remove(&v1.Status{ID: testInternalServerErrorId}, Alert)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"delete alert 8"}, dryRun.Plan.Changes)
+}
+
+func TestNilPlanNeverSkips(t *testing.T) {
+	var plan *Plan
+	assert.False(t, plan.Skip("create alert %q", "test"))
+}
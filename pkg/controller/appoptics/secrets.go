@@ -0,0 +1,123 @@
+package appoptics
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// SecretKeyRef is a service setting given as valueFrom.secretKeyRef, a key of a Secret in the
+// service's namespace
+type SecretKeyRef struct {
+	Name string
+	Key  string
+}
+
+// SecretKeyResolver returns the value of a key of a Secret
+type SecretKeyResolver func(name, key string) (string, error)
+
+// ClientSecretKeyResolver gets Secrets of a namespace from the API server, so only the Secrets a
+// service refers to are read. Missing Secrets and keys are not permanent errors, they may be created
+// after the service.
+func ClientSecretKeyResolver(secrets typedcorev1.SecretInterface) SecretKeyResolver {
+	return func(name, key string) (string, error) {
+		if secrets == nil {
+			return "", fmt.Errorf("Secret %s can not be read", name)
+		}
+		secret, err := secrets.Get(name, metav1.GetOptions{})
+		if err != nil {
+			return "", fmt.Errorf("Secret %s can not be read: %v", name, err)
+		}
+		value, ok := secret.Data[key]
+		if !ok {
+			return "", fmt.Errorf("Secret %s has no key %s", name, key)
+		}
+		return string(value), nil
+	}
+}
+
+// redactedPrefix starts the placeholder RedactSecretKeyRef puts in place of secret values
+const redactedPrefix = "secretKeyRef:"
+
+// RedactSecretKeyRef stands in for a SecretKeyResolver where the secret value must not end up, in
+// hashes, plans or printed diffs
+func RedactSecretKeyRef(name, key string) (string, error) {
+	return fmt.Sprintf("%s%s/%s", redactedPrefix, name, key), nil
+}
+
+func isRedactedSecretKeyRef(value string) bool {
+	return strings.HasPrefix(value, redactedPrefix)
+}
+
+// SecretKeyRefs returns the settings of a service's spec.data given as valueFrom.secretKeyRef, by
+// setting name
+func SecretKeyRefs(data string) (map[string]SecretKeyRef, error) {
+	var doc map[string]interface{}
+	err := yaml.Unmarshal([]byte(data), &doc)
+	if err != nil {
+		return nil, NewPermanentError(err)
+	}
+	settings, ok := doc["settings"].(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	refs := map[string]SecretKeyRef{}
+	for setting, value := range settings {
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		valueFrom, _ := fields["valueFrom"].(map[string]interface{})
+		secretKeyRef, _ := valueFrom["secretKeyRef"].(map[string]interface{})
+		name, _ := secretKeyRef["name"].(string)
+		key, _ := secretKeyRef["key"].(string)
+		if len(fields) != 1 || len(valueFrom) != 1 || name == "" || key == "" {
+			return nil, NewPermanentError(fmt.Errorf("settings.%s must be a string or valueFrom.secretKeyRef with a name and key", setting))
+		}
+		refs[setting] = SecretKeyRef{Name: name, Key: key}
+	}
+	return refs, nil
+}
+
+// ResolveSecretKeyRefs replaces the valueFrom.secretKeyRef settings in a service's spec.data with
+// what resolve returns for them. The data is returned unchanged when there are none.
+func ResolveSecretKeyRefs(data string, resolve SecretKeyResolver) (string, error) {
+	refs, err := SecretKeyRefs(data)
+	if err != nil || len(refs) == 0 {
+		return data, err
+	}
+
+	var doc map[string]interface{}
+	err = yaml.Unmarshal([]byte(data), &doc)
+	if err != nil {
+		return "", NewPermanentError(err)
+	}
+	settings := doc["settings"].(map[string]interface{})
+
+	// Resolve in a fixed order so the first missing Secret is always the one reported
+	var names []string
+	for setting := range refs {
+		names = append(names, setting)
+	}
+	sort.Strings(names)
+	for _, setting := range names {
+		ref := refs[setting]
+		value, err := resolve(ref.Name, ref.Key)
+		if err != nil {
+			return "", err
+		}
+		settings[setting] = value
+	}
+
+	resolved, err := json.Marshal(doc)
+	if err != nil {
+		return "", err
+	}
+	return string(resolved), nil
+}
@@ -0,0 +1,119 @@
+package appoptics
+
+import (
+	"testing"
+
+	"github.com/solarwinds/appoptics-kubernetes-controller/pkg/apis/appoptics-kubernetes-controller/v1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+const testSecretServiceData = `
+type: slack
+title: TEST
+settings:
+  url:
+    valueFrom:
+      secretKeyRef:
+        name: slack
+        key: webhook
+`
+
+// testSecrets gets the Secret "slack" of the namespace "default", the other methods are not implemented
+type testSecrets struct {
+	typedcorev1.SecretInterface
+	data map[string][]byte
+}
+
+func (s testSecrets) Get(name string, options metav1.GetOptions) (*corev1.Secret, error) {
+	if name != "slack" {
+		return nil, errors.NewNotFound(corev1.Resource("secrets"), name)
+	}
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+		Data:       s.data,
+	}, nil
+}
+
+func newTestSecrets(data map[string][]byte) typedcorev1.SecretInterface {
+	return testSecrets{data: data}
+}
+
+func TestSecretKeyRefs(t *testing.T) {
+	refs, err := SecretKeyRefs(testSecretServiceData)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, map[string]SecretKeyRef{"url": {Name: "slack", Key: "webhook"}}, refs)
+
+	refs, err = SecretKeyRefs(`{"type": "mail", "settings": {"addresses": "fred@example.com"}}`)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(refs))
+}
+
+func TestSecretKeyRefsMalformed(t *testing.T) {
+	_, err := SecretKeyRefs(`{"settings": {"url": {"valueFrom": {"secretKeyRef": {"name": "slack"}}}}}`)
+	assert.NotEqual(t, nil, err)
+	assert.True(t, IsPermanentError(err))
+}
+
+func TestResolveSecretKeyRefs(t *testing.T) {
+	resolve := ClientSecretKeyResolver(newTestSecrets(map[string][]byte{"webhook": []byte("https://hooks.example.com/secret")}))
+	data, err := ResolveSecretKeyRefs(testSecretServiceData, resolve)
+	assert.Equal(t, nil, err)
+	service, err := ParseService(data)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "https://hooks.example.com/secret", service.Settings["url"])
+
+	data, err = ResolveSecretKeyRefs(testSecretServiceData, RedactSecretKeyRef)
+	assert.Equal(t, nil, err)
+	service, err = ParseService(data)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "secretKeyRef:slack/webhook", service.Settings["url"])
+}
+
+func TestResolveSecretKeyRefsMissing(t *testing.T) {
+	_, err := ResolveSecretKeyRefs(testSecretServiceData, ClientSecretKeyResolver(newTestSecrets(nil)))
+	assert.NotEqual(t, nil, err)
+	assert.False(t, IsPermanentError(err))
+
+	_, err = ResolveSecretKeyRefs(testSecretServiceData, ClientSecretKeyResolver(nil))
+	assert.NotEqual(t, nil, err)
+}
+
+// Tests that the secret value is sent to AppOptics but left out of the hashes in the status
+func TestServiceSyncWithSecretKeyRef(t *testing.T) {
+	td := v1.TokenAndDataSpec{Namespace: "default", Data: testSecretServiceData, Secret: "blah"}
+	ctx := SyncContext{Namespace: "default", Secrets: newTestSecrets(map[string][]byte{"webhook": []byte("https://hooks.example.com/secret")})}
+
+	ts, err := aoc.Sync(td, &v1.Status{}, Service, ctx)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 145, ts.ID)
+
+	redacted, err := ResolveSecretKeyRefs(testSecretServiceData, RedactSecretKeyRef)
+	assert.Equal(t, nil, err)
+	service, err := ParseService(redacted)
+	assert.Equal(t, nil, err)
+	specHash, err := Hash(service)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, specHash, ts.Hashes.Spec)
+
+	_, err = aoc.Sync(td, &v1.Status{}, Service, SyncContext{Namespace: "default"})
+	assert.NotEqual(t, nil, err)
+}
+
+// Tests that a setting made invalid by its Secret is retried rather than failed until the spec changes
+func TestServiceSyncWithInvalidSecretValue(t *testing.T) {
+	td := v1.TokenAndDataSpec{Namespace: "default", Data: testSecretServiceData, Secret: "blah"}
+	ctx := SyncContext{Namespace: "default", Secrets: newTestSecrets(map[string][]byte{"webhook": []byte("not a url")})}
+
+	ts := v1.Status{}
+	_, err := aoc.Sync(td, &ts, Service, ctx)
+	assert.NotEqual(t, nil, err)
+	assert.False(t, IsPermanentError(err))
+	assert.NotContains(t, err.Error(), "not a url")
+	condition := ts.GetCondition(v1.ConditionSettingsValid)
+	assert.NotNil(t, condition)
+	assert.Equal(t, v1.ConditionFalse, condition.Status)
+}
@@ -1,79 +1,229 @@
 package appoptics
 
 import (
-	"encoding/json"
+	"bytes"
+	"fmt"
 	aoApi "github.com/appoptics/appoptics-api-go"
 	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
 	"github.com/solarwinds/appoptics-kubernetes-controller/pkg/apis/appoptics-kubernetes-controller/v1"
-	"reflect"
+	"strings"
 	"time"
 )
 
+const (
+	// SettingsValid is the reason of the SettingsValid condition when a service's settings are valid
+	SettingsValid = "SettingsValid"
+
+	// InvalidSettings is the reason of the SettingsValid condition when a service is missing a setting
+	// its type requires or a setting is malformed
+	InvalidSettings = "InvalidSettings"
+)
+
 type ServicesService struct {
 	aoApi.ServicesCommunicator
-	client *aoApi.Client
+	client     *aoApi.Client
+	plan       *Plan
+	secrets    SecretKeyResolver
+	owner      Ownership
+	checkpoint Checkpoint
 }
 
 func NewServicesService(c *aoApi.Client) *ServicesService {
-	return &ServicesService{c.ServicesService(), c}
+	return &ServicesService{c.ServicesService(), c, nil, ClientSecretKeyResolver(nil), Ownership{}, nil}
 }
 
 func (ss *ServicesService) Sync(spec v1.TokenAndDataSpec, status *v1.Status) (*v1.Status, error) {
-	var service aoApi.Service
-	err := yaml.Unmarshal([]byte(spec.Data), &service)
+	// Hashes are stored in the status, so they are taken with the secret values left out
+	redactedData, err := ResolveSecretKeyRefs(spec.Data, RedactSecretKeyRef)
+	if err != nil {
+		return nil, err
+	}
+	redacted, err := ParseService(redactedData)
+	if err != nil {
+		return nil, err
+	}
+	// Settings AppOptics would reject are reported before anything is sent to it. Problems in the
+	// spec are permanent, while those in values read from Secrets are retried so the service is
+	// synced once the Secret is fixed.
+	if message := serviceProblems(redacted); message != "" {
+		status.SetCondition(v1.ConditionSettingsValid, v1.ConditionFalse, InvalidSettings, message)
+		return nil, NewPermanentError(fmt.Errorf("invalid %s service: %s", stringValue(redacted.Type), message))
+	}
+	data, err := ResolveSecretKeyRefs(spec.Data, ss.secrets)
 	if err != nil {
 		return nil, err
 	}
+	service, err := ParseService(data)
+	if err != nil {
+		return nil, err
+	}
+	if message := serviceProblems(service); message != "" {
+		status.SetCondition(v1.ConditionSettingsValid, v1.ConditionFalse, InvalidSettings, message)
+		return nil, fmt.Errorf("invalid %s service, from its Secrets: %s", stringValue(service.Type), message)
+	}
+	status.SetCondition(v1.ConditionSettingsValid, v1.ConditionTrue, SettingsValid, "")
+
+	if !ss.owner.IsZero() {
+		title := ss.owner.MarkName(stringValue(service.Title))
+		service.Title = &title
+		redacted.Title = &title
+	}
 
 	// If we dont have an ID for it then we assume its new and create it
 	if status.ID == 0 {
-		return ss.createService(service, status)
-	} else {
-		// Lets ensure that the ID we have exists in AppOptics
-		aoService, err := ss.Retrieve(status.ID)
-		if err != nil {
-			if CheckIfErrorIsAppOpticsNotFoundError(err, Service, status.ID) {
-				return ss.createService(service, status)
-			} else {
-				return nil, err
-			}
-		} else {
-			//Service exists in AppOptics now lets check that they are actually synced
-			service.ID = &status.ID
-			if !reflect.DeepEqual(&service, aoService) {
-				// Local vs Remote are different so update AO
-				err = ss.Update(&service)
-				if err != nil {
-					return nil, err
-				}
-				status.UpdatedAt = int(time.Now().Unix())
-				serviceJson, err := json.Marshal(aoService)
-				if err != nil {
-					return nil, err
-				}
-				status.Hashes.AppOptics, err = Hash(serviceJson)
-				if err != nil {
-					return nil, err
-				}
-			}
+		return ss.createService(service, redacted, status)
+	}
+	// Lets ensure that the ID we have exists in AppOptics
+	aoService, err := ss.Retrieve(status.ID)
+	if err != nil {
+		if CheckIfErrorIsAppOpticsNotFoundError(err, Service, status.ID) {
+			return ss.createService(service, redacted, status)
 		}
+		return nil, err
+	}
+	return ss.updateService(service, redacted, aoService, status)
+}
+
+// serviceProblems joins the problems ValidateService finds in a service, empty when there are none
+func serviceProblems(service aoApi.Service) string {
+	var messages []string
+	for _, problem := range ValidateService(service) {
+		messages = append(messages, problem.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// updateService brings an existing AppOptics service in line with the desired service. It is updated
+// when the spec changed since the last sync, when the service was changed in AppOptics or when a
+// setting read from a Secret differs.
+func (ss *ServicesService) updateService(service aoApi.Service, redacted aoApi.Service, aoService *aoApi.Service, status *v1.Status) (*v1.Status, error) {
+	_, owner, marked := ParseMarkedName(stringValue(aoService.Title))
+	if err := ss.owner.checkOwner(Service, status.ID, owner, marked); err != nil {
+		return nil, err
+	}
+	specHash, err := Hash(redacted)
+	if err != nil {
+		return nil, err
+	}
+	aoHash, secretsChanged, err := remoteServiceHash(aoService, service, redacted)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(specHash, status.Hashes.Spec) && bytes.Equal(aoHash, status.Hashes.AppOptics) && !secretsChanged {
+		return status, nil
+	}
+	if ss.plan.Skip("update service %d", status.ID) {
+		return status, nil
 	}
 
+	service.ID = &status.ID
+	err = ss.Update(&service)
+	if err != nil {
+		return nil, err
+	}
+	aoService, err = ss.Retrieve(status.ID)
+	if err != nil {
+		return nil, err
+	}
+	status.Hashes.AppOptics, _, err = remoteServiceHash(aoService, service, redacted)
+	if err != nil {
+		return nil, err
+	}
+	status.Hashes.Spec = specHash
+	status.UpdatedAt = int(time.Now().Unix())
 	return status, nil
+}
+
+// remoteServiceHash hashes a service as AppOptics returned it, with the settings read from Secrets
+// redacted like in the desired service so no secret value ends up in the status. Whether those
+// settings differ from the desired values is reported separately.
+func remoteServiceHash(aoService *aoApi.Service, service aoApi.Service, redacted aoApi.Service) ([]byte, bool, error) {
+	remote := *aoService
+	remote.Settings = map[string]string{}
+	for key, value := range aoService.Settings {
+		remote.Settings[key] = value
+	}
+	secretsChanged := false
+	for key, value := range redacted.Settings {
+		if value == service.Settings[key] {
+			continue
+		}
+		if remote.Settings[key] != service.Settings[key] {
+			secretsChanged = true
+		}
+		remote.Settings[key] = value
+	}
+	hash, err := Hash(&remote)
+	return hash, secretsChanged, err
+}
+
+// FindByTitle returns the AppOptics service with the given title, ignoring any ownership marker, or
+// nil if there is none
+func (ss *ServicesService) FindByTitle(title string) (*aoApi.Service, error) {
+	services, err := ss.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, service := range services.Services {
+		if UnmarkedName(stringValue(service.Title)) == title {
+			return service, nil
+		}
+	}
+	return nil, nil
+}
 
+// ParseService decodes the spec.data of an AppOpticsService
+func ParseService(data string) (aoApi.Service, error) {
+	var service aoApi.Service
+	err := yaml.Unmarshal([]byte(data), &service)
+	if err != nil {
+		return service, NewPermanentError(err)
+	}
+	return service, nil
 }
 
-func (ss *ServicesService) createService(service aoApi.Service, status *v1.Status) (*v1.Status, error) {
+func (ss *ServicesService) createService(service aoApi.Service, redacted aoApi.Service, status *v1.Status) (*v1.Status, error) {
+	// A service with the title, which carries the ownership marker, was left by an earlier sync whose
+	// ID was never stored and is reused rather than duplicated
+	services, err := ss.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, existing := range services.Services {
+		if existing.ID != nil && stringValue(existing.Title) == stringValue(service.Title) {
+			aoService, err := ss.Retrieve(*existing.ID)
+			if err != nil {
+				return nil, err
+			}
+			glog.Infof("Adopting existing service %d %q", *existing.ID, stringValue(service.Title))
+			status.ID = *existing.ID
+			if err := ss.checkpoint.save(status.ID); err != nil {
+				return nil, err
+			}
+			return ss.updateService(service, redacted, aoService, status)
+		}
+	}
+
+	if ss.plan.Skip("create service %q", stringValue(service.Title)) {
+		return status, nil
+	}
 	aoService, err := ss.Create(&service)
 	if err != nil {
 		return nil, err
 	}
 	status.ID = *aoService.ID
+	if err := ss.checkpoint.save(status.ID); err != nil {
+		return nil, err
+	}
 	status.UpdatedAt = int(time.Now().Unix())
-	hash, err := json.Marshal(aoService)
+	status.Hashes.Spec, err = Hash(redacted)
+	if err != nil {
+		return nil, err
+	}
+	status.Hashes.AppOptics, _, err = remoteServiceHash(aoService, service, redacted)
 	if err != nil {
 		return nil, err
 	}
-	status.Hashes.AppOptics = hash
 	return status, nil
 }
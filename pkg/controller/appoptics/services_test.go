@@ -29,7 +29,7 @@ func TestExistingServiceSyncSuccess(t *testing.T) {
 
 	td := v1.TokenAndDataSpec{Namespace: "Default", Data: data, Secret: "blah"}
 
-	ts1, err := aoc.Sync(td, &ts, Service, nil)
+	ts1, err := aoc.Sync(td, &ts, Service, SyncContext{})
 	if err != nil {
 		t.Errorf("error running TestExistingServiceSync: %v", err)
 	}
@@ -37,6 +37,28 @@ func TestExistingServiceSyncSuccess(t *testing.T) {
 	assert.Equal(t, ts1.ID, ts.ID)
 }
 
+// Tests that an unchanged service is left alone and that a service changed in AppOptics is updated
+func TestExistingServiceSyncDetectsDrift(t *testing.T) {
+	ts := v1.Status{ID: 1}
+	data := `{"type": "mail", "settings": {"addresses": "george@example.com"}, "title": "TEST"}`
+	td := v1.TokenAndDataSpec{Namespace: "Default", Data: data, Secret: "blah"}
+
+	synced, err := aoc.Sync(td, &ts, Service, SyncContext{})
+	assert.Nil(t, err)
+	assert.NotEmpty(t, synced.Hashes.Spec)
+	assert.NotEmpty(t, synced.Hashes.AppOptics)
+
+	synced.UpdatedAt = 0
+	synced, err = aoc.Sync(td, synced, Service, SyncContext{})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, synced.UpdatedAt)
+
+	synced.Hashes.AppOptics = []byte("changed in AppOptics")
+	synced, err = aoc.Sync(td, synced, Service, SyncContext{})
+	assert.Nil(t, err)
+	assert.NotEqual(t, 0, synced.UpdatedAt)
+}
+
 // This tests an Existing Service failing to be updated
 func TestUpdateExistingServicesSyncFailure(t *testing.T) {
 
@@ -53,7 +75,7 @@ func TestUpdateExistingServicesSyncFailure(t *testing.T) {
 
 	td := v1.TokenAndDataSpec{Namespace: "Default", Data: data, Secret: "blah"}
 
-	_, err := aoc.Sync(td, &ts, Service, nil)
+	_, err := aoc.Sync(td, &ts, Service, SyncContext{})
 	assert.NotEqual(t, nil, err)
 	assert.Equal(t, `{"errors":{"request":["Test Error"]}}`, err.Error())
 }
@@ -75,7 +97,7 @@ func TestNewServiceSyncSuccess(t *testing.T) {
 
 	td := v1.TokenAndDataSpec{Namespace: "Default", Data: data, Secret: "blah"}
 
-	ts1, err := aoc.Sync(td, &ts, Service, nil)
+	ts1, err := aoc.Sync(td, &ts, Service, SyncContext{})
 	if err != nil {
 		t.Errorf("error running TestExistingServiceSync: %v", err)
 	}
@@ -84,7 +106,22 @@ func TestNewServiceSyncSuccess(t *testing.T) {
 	assert.Equal(t, 145, ts1.ID)
 }
 
-// Test recreating a Service that has been deleting in AO but exists as a CRD still
+// Tests that a service left by a sync whose ID was never stored is reused rather than duplicated
+func TestNewServiceSyncAdoptsExistingService(t *testing.T) {
+	owned := &AOCommunicator{Client: *client, Owner: Ownership{ClusterID: "this-cluster", Namespace: "monitoring", Name: "ops"}}
+	data := `
+type: mail
+title: Notify Ops Room
+settings:
+  addresses: ops@example.com
+`
+	td := v1.TokenAndDataSpec{Namespace: "monitoring", Data: data, Secret: "blah"}
+
+	ts, err := owned.Sync(td, &v1.Status{}, Service, SyncContext{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 146, ts.ID)
+}
+
 func TestDeletedInAppopticsButNotInCRDServiceSyncSuccess(t *testing.T) {
 	newID := 145
 
@@ -101,7 +138,7 @@ func TestDeletedInAppopticsButNotInCRDServiceSyncSuccess(t *testing.T) {
 
 	td := v1.TokenAndDataSpec{Namespace: "Default", Data: data, Secret: "blah"}
 
-	ts1, err := aoc.Sync(td, &ts, Service, nil)
+	ts1, err := aoc.Sync(td, &ts, Service, SyncContext{})
 	if err != nil {
 		t.Errorf("error running TestSpacesSync: %v", err)
 	}
@@ -127,7 +164,7 @@ func TestNewServiceCreateErrorInAppopticsFailure(t *testing.T) {
 
 	td := v1.TokenAndDataSpec{Namespace: "Default", Data: data, Secret: "blah"}
 
-	_, err := aoc.Sync(td, &ts, Service, nil)
+	_, err := aoc.Sync(td, &ts, Service, SyncContext{})
 	assert.NotEqual(t, nil, err)
 	assert.Equal(t, `{"errors":{"request":["Test Error"]}}`, err.Error())
 }
@@ -148,7 +185,7 @@ func TestMissingServiceCreateErrorInAppopticsFailure(t *testing.T) {
 
 	td := v1.TokenAndDataSpec{Namespace: "Default", Data: data, Secret: "blah"}
 
-	_, err := aoc.Sync(td, &ts, Service, nil)
+	_, err := aoc.Sync(td, &ts, Service, SyncContext{})
 	assert.NotEqual(t, nil, err)
 	assert.Equal(t, `{"errors":{"request":["Test Error"]}}`, err.Error())
 }
@@ -168,13 +205,49 @@ func TestOutOfSyncServiceCreateErrorThenRetrieveErrorInAppoptics(t *testing.T) {
            }`
 	td := v1.TokenAndDataSpec{Namespace: "Default", Data: data, Secret: "blah"}
 
-	_, err := aoc.Sync(td, &ts, Service, nil)
+	_, err := aoc.Sync(td, &ts, Service, SyncContext{})
 	assert.NotEqual(t, nil, err)
 	assert.Equal(t, `{"errors":{"request":["Test Error"]}}`, err.Error())
 }
 
+// Tests that a service missing a setting its type requires is rejected before AppOptics is called
+func TestServiceSyncInvalidSettings(t *testing.T) {
+	ts := v1.Status{ID: 0, LastUpdated: "Yesterday"}
+
+	data := `
+type: webhook
+title: Deploys
+settings:
+  url: "hooks.example.com/deploy"
+`
+	td := v1.TokenAndDataSpec{Namespace: "Default", Data: data, Secret: "blah"}
+
+	_, err := aoc.Sync(td, &ts, Service, SyncContext{})
+	assert.NotEqual(t, nil, err)
+	assert.True(t, IsPermanentError(err))
+	assert.Equal(t, 0, ts.ID)
+	assert.Equal(t, v1.ConditionFalse, ts.GetCondition(v1.ConditionSettingsValid).Status)
+	assert.Equal(t, "settings.url must be an absolute http or https URL", ts.GetCondition(v1.ConditionSettingsValid).Message)
+}
+
+func TestValidateServiceSettings(t *testing.T) {
+	mail := "mail"
+	pagerduty := "pagerduty"
+	title := "TEST"
+
+	problems := ValidateService(aoApi.Service{Type: &mail, Title: &title, Settings: map[string]string{"addresses": "fred@example.com, george@example.com"}})
+	assert.Equal(t, 0, len(problems))
+
+	problems = ValidateService(aoApi.Service{Type: &mail, Title: &title, Settings: map[string]string{"addresses": "fred@example.com,george"}})
+	assert.Equal(t, 1, len(problems))
+
+	problems = ValidateService(aoApi.Service{Type: &pagerduty, Title: &title})
+	assert.Equal(t, 1, len(problems))
+	assert.Equal(t, "settings.service_key is required for pagerduty services", problems[0].Error())
+}
+
 func TestDeletingServiceSuccessSync(t *testing.T) {
-	err := aoc.Remove(1, Service)
+	err := aoc.Remove(&v1.Status{ID: 1}, Service)
 	if err != nil {
 		t.Errorf("error running TestSpacesSync: %v", err)
 	}
@@ -183,7 +256,7 @@ func TestDeletingServiceSuccessSync(t *testing.T) {
 }
 
 func TestDeletingServiceErrorSync(t *testing.T) {
-	err := aoc.Remove(testInternalServerErrorId, Service)
+	err := aoc.Remove(&v1.Status{ID: testInternalServerErrorId}, Service)
 
 	assert.Equal(t, err.Error(), `{"errors":{"request":["Internal Server Error"]}}`)
 }
@@ -275,3 +348,16 @@ func DeleteServiceHandler() http.HandlerFunc {
 		return
 	}
 }
+
+func ListServicesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		responseBody := `{
+  "query": {"found": 2, "length": 2, "offset": 0, "total": 2},
+  "services": [
+    {"id": 145, "type": "mail", "title": "Notify Ops Room", "settings": {"addresses": "ops@example.com"}},
+    {"id": 146, "type": "mail", "title": "Notify Ops Room [k8s:this-cluster/monitoring/ops]", "settings": {"addresses": "ops@example.com"}}
+  ]
+}`
+		w.Write([]byte(responseBody))
+	}
+}
@@ -10,12 +10,12 @@ import (
 	"github.com/appoptics/appoptics-api-go"
 	"github.com/gorilla/mux"
 	"github.com/solarwinds/appoptics-kubernetes-controller/pkg/apis/appoptics-kubernetes-controller/v1"
-	v12 "github.com/solarwinds/appoptics-kubernetes-controller/pkg/client/listers/appoptics-kubernetes-controller/v1"
+	listers "github.com/solarwinds/appoptics-kubernetes-controller/pkg/client/listers/appoptics-kubernetes-controller/v1"
 	"io"
 	"io/ioutil"
-	v13 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
-	"k8s.io/client-go/tools/cache"
 	"strings"
 )
 
@@ -28,6 +28,9 @@ var (
 const testNotFoundId int = 9
 const testInternalServerErrorId int = 8
 
+// testForeignOwnedId is a space marked as owned by the controller of another cluster
+const testForeignOwnedId int = 10
+
 func setup() {
 	router := NewServerTestMux()
 	server = httptest.NewServer(router)
@@ -52,6 +55,7 @@ func NewServerTestMux() *mux.Router {
 
 	// Spaces
 	router.Handle("/v1/spaces", CreateSpaceHandler()).Methods("POST")
+	router.Handle("/v1/spaces", ListSpacesHandler()).Methods("GET")
 	router.Handle("/v1/spaces/{id}", RetrieveSpaceHandler()).Methods("GET")
 	router.Handle("/v1/spaces/{id}", UpdateSpaceHandler()).Methods("PUT")
 	router.Handle("/v1/spaces/{id}", DeleteSpaceHandler()).Methods("DELETE")
@@ -63,18 +67,26 @@ func NewServerTestMux() *mux.Router {
 
 	// Services
 	router.Handle("/v1/services", CreateServiceHandler()).Methods("POST")
+	router.Handle("/v1/services", ListServicesHandler()).Methods("GET")
 	router.Handle("/v1/services/{serviceId}", RetrieveServiceHandler()).Methods("GET")
 	router.Handle("/v1/services/{serviceId}", UpdateServiceHandler()).Methods("PUT")
 	router.Handle("/v1/services/{serviceId}", DeleteServiceHandler()).Methods("DELETE")
 
 	// Alerts
 	router.Handle("/v1/alerts", CreateAlertHandler()).Methods("POST")
+	router.Handle("/v1/alerts", ListAlertsHandler()).Methods("GET")
 	router.Handle("/v1/alerts/{alertId}", RetrieveAlertHandler()).Methods("GET")
 	router.Handle("/v1/alerts/{alertId}", UpdateAlertHandler()).Methods("PUT")
 	router.Handle("/v1/alerts/{alertId}", DeleteAlertHandler()).Methods("DELETE")
+	router.Handle("/v1/alerts/{alertId}/status", AlertStatusHandler()).Methods("GET")
 	router.Handle("/v1/alerts/{alertId}/services", AssociateAlertHandler()).Methods("POST")
 	router.Handle("/v1/alerts/{alertId}/services/{serviceId}", DisassociateAlertHandler()).Methods("DELETE")
 
+	// Metrics
+	router.Handle("/v1/metrics/{name}", RetrieveMetricHandler()).Methods("GET")
+	router.Handle("/v1/metrics/{name}", UpdateMetricHandler()).Methods("PUT")
+	router.Handle("/v1/metrics/{name}", DeleteMetricHandler()).Methods("DELETE")
+
 	return router
 }
 
@@ -112,7 +124,8 @@ type mockAOCommunicator struct {
 	Token string
 }
 
-func (maoc *mockAOCommunicator) Remove(ID int, kind string) error {
+func (maoc *mockAOCommunicator) Remove(status *v1.Status, kind string) error {
+	ID := status.ID
 	switch strings.ToLower(kind) {
 	case Dashboard:
 		spacesService := NewSpacesService(client)
@@ -121,54 +134,71 @@ func (maoc *mockAOCommunicator) Remove(ID int, kind string) error {
 		servicesService := NewServicesService(client)
 		return servicesService.Delete(ID)
 	case Alert:
-		alertsService := NewAlertsService(client, nil)
+		alertsService := NewAlertsService(client, nil, "")
 		return alertsService.Delete(ID)
+	case Metric:
+		metricsService := NewMetricsService(client)
+		return metricsService.Delete(status.Name)
 	}
 	return nil
 }
 
-func (maoc *mockAOCommunicator) Sync(spec v1.TokenAndDataSpec, status *v1.Status, kind string, lister v12.AppOpticsServiceNamespaceLister) (*v1.Status, error) {
+func (maoc *mockAOCommunicator) Sync(spec v1.TokenAndDataSpec, status *v1.Status, kind string, ctx SyncContext) (*v1.Status, error) {
 	switch strings.ToLower(kind) {
 	case Dashboard:
 		spacesService := NewSpacesService(client)
 		return spacesService.Sync(spec, status)
 	case Service:
 		servicesService := NewServicesService(client)
+		servicesService.secrets = ClientSecretKeyResolver(ctx.Secrets)
 		return servicesService.Sync(spec, status)
 	case Alert:
-		alertService := NewAlertsService(client, lister)
+		alertService := NewAlertsService(client, ctx.Services, ctx.Namespace)
+		alertService.maintenanceWindows = ctx.MaintenanceWindows
 		return alertService.Sync(spec, status)
+	case Metric:
+		metricsService := NewMetricsService(client)
+		return metricsService.Sync(spec, status)
 	}
 	return status, nil
 }
 
-// serviceNamespaceLister implements the ServiceNamespaceLister
-// interface.
-type mockServiceLister struct {
-	indexer   cache.Indexer
-	namespace string
+const (
+	testMissingServiceName = "missing"
+	testPendingServiceName = "pending"
+)
+
+// mockServiceLister implements the AppOpticsServiceLister interface. Every service exists and is
+// synced with ID 1, except testMissingServiceName which does not exist and testPendingServiceName
+// which has not been synced yet.
+type mockServiceLister struct{}
+
+func (msl *mockServiceLister) List(selector labels.Selector) ([]*v1.AppOpticsService, error) {
+	return nil, nil
 }
 
-// List lists all Services in the indexer for a given namespace.
-func (s mockServiceLister) List(selector labels.Selector) (ret []*v1.AppOpticsService, err error) {
-	err = cache.ListAllByNamespace(s.indexer, s.namespace, selector, func(m interface{}) {
-		ret = append(ret, m.(*v1.AppOpticsService))
-	})
-	return ret, err
+func (msl *mockServiceLister) AppOpticsServices(namespace string) listers.AppOpticsServiceNamespaceLister {
+	return &mockServiceNamespaceLister{namespace: namespace}
 }
 
-func (msl *mockServiceLister) Get(name string) (*v1.AppOpticsService, error) {
-	tss := v1.Status{ID: 1}
-	service := v1.AppOpticsService{Status: tss}
-	return &service, nil
+type mockServiceNamespaceLister struct {
+	namespace string
 }
 
-func testIndexFunc(obj interface{}) ([]string, error) {
-	pod := obj.(v13.Pod)
-	return []string{pod.Labels["foo"]}, nil
+func (msnl *mockServiceNamespaceLister) List(selector labels.Selector) ([]*v1.AppOpticsService, error) {
+	return nil, nil
 }
+
+func (msnl *mockServiceNamespaceLister) Get(name string) (*v1.AppOpticsService, error) {
+	switch name {
+	case testMissingServiceName:
+		return nil, errors.NewNotFound(v1.Resource("appopticsservice"), name)
+	case testPendingServiceName:
+		return &v1.AppOpticsService{}, nil
+	}
+	return &v1.AppOpticsService{ObjectMeta: metav1.ObjectMeta{Namespace: msnl.namespace, Name: name}, Status: v1.Status{ID: 1}}, nil
+}
+
 func NewMockLister() *mockServiceLister {
-	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{"testmodes": testIndexFunc})
-	msl := mockServiceLister{namespace: "test", indexer: indexer}
-	return &msl
+	return &mockServiceLister{}
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	aoApi "github.com/appoptics/appoptics-api-go"
 	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
 	"github.com/solarwinds/appoptics-kubernetes-controller/pkg/apis/appoptics-kubernetes-controller/v1"
 	"strings"
 )
@@ -15,16 +16,18 @@ type CustomSpace struct {
 
 type SpacesService struct {
 	aoApi.SpacesCommunicator
-	client *aoApi.Client
+	client     *aoApi.Client
+	plan       *Plan
+	owner      Ownership
+	checkpoint Checkpoint
 }
 
 func NewSpacesService(c *aoApi.Client) *SpacesService {
-	return &SpacesService{c.SpacesService(), c}
+	return &SpacesService{c.SpacesService(), c, nil, Ownership{}, nil}
 }
 
 func (s *SpacesService) Sync(spec v1.TokenAndDataSpec, status *v1.Status) (*v1.Status, error) {
-	var dash CustomSpace
-	err := yaml.Unmarshal([]byte(spec.Data), &dash)
+	dash, err := ParseSpace(spec.Data)
 	if err != nil {
 		return nil, err
 	}
@@ -35,12 +38,13 @@ func (s *SpacesService) Sync(spec v1.TokenAndDataSpec, status *v1.Status) (*v1.S
 		return nil, err
 	}
 	chartService := NewChartsService(s.client)
+	chartService.plan = s.plan
 	aoChartHash, err := chartService.getChartHash(status.ID)
 	if err != nil {
 		return nil, err
 	}
 
-	specHash, err := Hash(spec)
+	specHash, err := Hash(dash)
 	if err != nil {
 		return nil, err
 	}
@@ -61,32 +65,54 @@ func (s *SpacesService) Sync(spec v1.TokenAndDataSpec, status *v1.Status) (*v1.S
 	return status, nil
 }
 
+// FindByName returns the AppOptics space with the given name, ignoring any ownership marker, or nil
+// if there is none
+func (s *SpacesService) FindByName(name string) (*aoApi.Space, error) {
+	spaces, err := s.List(nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, space := range spaces {
+		if UnmarkedName(space.Name) == name {
+			return space, nil
+		}
+	}
+	return nil, nil
+}
+
+// ParseSpace decodes the spec.data of an AppOpticsDashboard
+func ParseSpace(data string) (CustomSpace, error) {
+	var dash CustomSpace
+	err := yaml.Unmarshal([]byte(data), &dash)
+	if err != nil {
+		return dash, NewPermanentError(err)
+	}
+	return dash, nil
+}
+
 func (s *SpacesService) sync(dash CustomSpace, status *v1.Status) (*v1.Status, error) {
+	name := s.owner.MarkName(dash.Name)
 	// If we dont have an ID for it then we assume its new and create it
 	if status.ID == 0 {
-		space, err := s.Create(dash.Name)
-		if err != nil {
-			return nil, err
-		}
-		status.ID = space.ID
+		return s.create(name, "create dashboard %q", status)
 	} else {
 		// Lets ensure that the ID we have exists in AppOptics
 		aoSpace, err := s.Retrieve(status.ID)
 		if err != nil {
 			// If its a not found error thats ok we can try to create it now
 			if CheckIfErrorIsAppOpticsNotFoundError(err, Dashboard, status.ID) {
-				space, err := s.Create(dash.Name)
-				if err != nil {
-					return nil, err
-				}
-				status.ID = space.ID
+				return s.create(name, "recreate dashboard %q missing from AppOptics", status)
 			} else {
 				return nil, err
 			}
 		} else {
+			_, owner, marked := ParseMarkedName(aoSpace.Name)
+			if err := s.owner.checkOwner(Dashboard, status.ID, owner, marked); err != nil {
+				return nil, err
+			}
 			//Service exists in AppOptics now lets check that they are actually synced
-			if strings.Compare(aoSpace.Name, dash.Name) != 0 {
-				_, err = s.Update(status.ID, dash.Name)
+			if strings.Compare(aoSpace.Name, name) != 0 && !s.plan.Skip("rename dashboard %d from %q to %q", status.ID, aoSpace.Name, name) {
+				_, err = s.Update(status.ID, name)
 				if err != nil {
 					return nil, err
 				}
@@ -96,3 +122,29 @@ func (s *SpacesService) sync(dash CustomSpace, status *v1.Status) (*v1.Status, e
 	return status, nil
 
 }
+
+// create reuses the space with the name, left by an earlier sync whose ID was never stored, or else
+// creates one. The name carries the ownership marker, so only a space of the same resource is reused.
+func (s *SpacesService) create(name string, change string, status *v1.Status) (*v1.Status, error) {
+	spaces, err := s.List(nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, space := range spaces {
+		if space.Name == name {
+			glog.Infof("Adopting existing dashboard %d %q", space.ID, name)
+			status.ID = space.ID
+			return status, s.checkpoint.save(status.ID)
+		}
+	}
+
+	if s.plan.Skip(change, name) {
+		return status, nil
+	}
+	space, err := s.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	status.ID = space.ID
+	return status, s.checkpoint.save(status.ID)
+}
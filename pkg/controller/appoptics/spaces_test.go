@@ -38,7 +38,7 @@ charts:
 
 	td := v1.TokenAndDataSpec{Namespace: "Default", Data: data, Secret: "blah"}
 
-	ts1, err := aoc.Sync(td, &ts, Dashboard, nil)
+	ts1, err := aoc.Sync(td, &ts, Dashboard, SyncContext{})
 	if err != nil {
 		t.Errorf("error running TestSpacesSync: %v", err)
 	}
@@ -58,7 +58,7 @@ charts:
 
 	td := v1.TokenAndDataSpec{Namespace: "Default", Data: data, Secret: "blah"}
 
-	ts1, err := aoc.Sync(td, &ts, Dashboard, nil)
+	ts1, err := aoc.Sync(td, &ts, Dashboard, SyncContext{})
 	if err != nil {
 		t.Errorf("error running TestSpacesSync: %v", err)
 	}
@@ -66,6 +66,16 @@ charts:
 	assert.Equal(t, 1, ts1.ID)
 }
 
+// Tests that a space left by a sync whose ID was never stored is reused rather than duplicated
+func TestNewSpacesSyncAdoptsExistingSpace(t *testing.T) {
+	owned := &AOCommunicator{Client: *client, Owner: Ownership{ClusterID: "this-cluster", Namespace: "default", Name: "cpus"}}
+	td := v1.TokenAndDataSpec{Namespace: "default", Data: "name: CPUs", Secret: "blah"}
+
+	ts, err := owned.Sync(td, &v1.Status{}, Dashboard, SyncContext{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 5, ts.ID)
+}
+
 func TestDeletedInAppopticsButNotInCRDSpacesSync(t *testing.T) {
 	invalidID := testNotFoundId
 	newID := 1
@@ -79,7 +89,7 @@ charts:
 
 	td := v1.TokenAndDataSpec{Namespace: "Default", Data: data, Secret: "blah"}
 
-	ts1, err := aoc.Sync(td, &ts, Dashboard, nil)
+	ts1, err := aoc.Sync(td, &ts, Dashboard, SyncContext{})
 	if err != nil {
 		t.Errorf("error running TestSpacesSync: %v", err)
 	}
@@ -100,7 +110,7 @@ charts:
 
 	td := v1.TokenAndDataSpec{Namespace: "Default", Data: data, Secret: "blah"}
 
-	_, err := aoc.Sync(td, &ts, Dashboard, nil)
+	_, err := aoc.Sync(td, &ts, Dashboard, SyncContext{})
 	assert.NotEqual(t, nil, err)
 	assert.Equal(t, `{"errors":{"request":["Internal Server Error"]}}`, err.Error())
 }
@@ -115,7 +125,7 @@ charts:
 `
 	td := v1.TokenAndDataSpec{Namespace: "Default", Data: data, Secret: "blah"}
 
-	_, err := aoc.Sync(td, &ts, Dashboard, nil)
+	_, err := aoc.Sync(td, &ts, Dashboard, SyncContext{})
 	assert.NotEqual(t, nil, err)
 	assert.Equal(t, `{"errors":{"request":["Internal Server Error"]}}`, err.Error())
 }
@@ -130,7 +140,7 @@ charts:
 `
 	td := v1.TokenAndDataSpec{Namespace: "Default", Data: data, Secret: "blah"}
 
-	_, err := aoc.Sync(td, &ts, Dashboard, nil)
+	_, err := aoc.Sync(td, &ts, Dashboard, SyncContext{})
 	assert.NotEqual(t, nil, err)
 	assert.Equal(t, `{"errors":{"request":["Internal Server Error"]}}`, err.Error())
 }
@@ -147,7 +157,7 @@ charts:
 
 	td := v1.TokenAndDataSpec{Namespace: "Default", Data: data, Secret: "blah"}
 
-	_, err := aoc.Sync(td, &ts, Dashboard, nil)
+	_, err := aoc.Sync(td, &ts, Dashboard, SyncContext{})
 	assert.NotEqual(t, nil, err)
 	assert.Equal(t, `{"errors":{"request":["`+spaceError+`"]}}`, err.Error())
 }
@@ -172,13 +182,13 @@ charts:
 
 	td := v1.TokenAndDataSpec{Namespace: "Default", Data: data, Secret: "blah"}
 
-	_, err := aoc.Sync(td, &ts, Dashboard, nil)
+	_, err := aoc.Sync(td, &ts, Dashboard, SyncContext{})
 
 	assert.Equal(t, err.Error(), `{"errors":{"request":["Internal Server Error"]}}`)
 }
 
 func TestDeletingSpaceSuccessSync(t *testing.T) {
-	err := aoc.Remove(1, Dashboard)
+	err := aoc.Remove(&v1.Status{ID: 1}, Dashboard)
 	if err != nil {
 		t.Errorf("error running TestSpacesSync: %v", err)
 	}
@@ -187,7 +197,7 @@ func TestDeletingSpaceSuccessSync(t *testing.T) {
 }
 
 func TestDeletingSpaceErrorSync(t *testing.T) {
-	err := aoc.Remove(testInternalServerErrorId, Dashboard)
+	err := aoc.Remove(&v1.Status{ID: testInternalServerErrorId}, Dashboard)
 	assert.Equal(t, err.Error(), `{"errors":{"request":["Internal Server Error"]}}`)
 }
 
@@ -229,6 +239,9 @@ func RetrieveSpaceHandler() http.HandlerFunc {
 		} else if ID == testInternalServerErrorId {
 			http.Error(w, `{"errors":{"request":["Internal Server Error"]}}`, http.StatusInternalServerError)
 			return
+		} else if ID == testForeignOwnedId {
+			w.Write([]byte(`{"name": "CPUs [k8s:other-cluster/default/cpus]", "id": ` + vars["id"] + `}`))
+			return
 		}
 		responseBody := `{
   "name": "CPUs",
@@ -291,3 +304,17 @@ func DeleteSpaceHandler() http.HandlerFunc {
 		return
 	}
 }
+
+func ListSpacesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		responseBody := `{
+  "query": {"found": 3, "length": 3, "offset": 0, "total": 3},
+  "spaces": [
+    {"id": 4, "name": "CPUs"},
+    {"id": 5, "name": "CPUs [k8s:this-cluster/default/cpus]"},
+    {"id": 6, "name": "CPUs [k8s:other-cluster/default/cpus]"}
+  ]
+}`
+		w.Write([]byte(responseBody))
+	}
+}
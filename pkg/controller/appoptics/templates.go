@@ -0,0 +1,194 @@
+package appoptics
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+// Workload is a Deployment or StatefulSet that resources are generated for
+type Workload struct {
+	Kind      string
+	Namespace string
+	Name      string
+	// Selector holds the matchLabels of the workload's selector
+	Selector map[string]string
+}
+
+// PodTags scopes a stream or alert condition to the workload's pods, by their namespace and the
+// labels of the workload's selector, grouped by pod. Pod names are not used since the pods of
+// another workload may start with the same name.
+func (w Workload) PodTags() ([]interface{}, error) {
+	if len(w.Selector) == 0 {
+		return nil, NewPermanentError(fmt.Errorf("%s %s/%s has no selector matchLabels to find its pods by", w.Kind, w.Namespace, w.Name))
+	}
+	tags := []interface{}{map[string]interface{}{"name": "namespace", "values": []string{w.Namespace}}}
+	var keys []string
+	for key := range w.Selector {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		tags = append(tags, map[string]interface{}{"name": key, "values": []string{w.Selector[key]}})
+	}
+	tags = append(tags, map[string]interface{}{"name": "pod_name", "values": []string{"*"}, "grouped": true})
+	return tags, nil
+}
+
+// workloadChart is a chart of a dashboard template, with one stream of metric summed per pod
+type workloadChart struct {
+	name   string
+	metric string
+}
+
+// dashboardTemplates holds the dashboards that can be generated for workloads, by the name used in
+// the appoptics.io/dashboard-template annotation. The metrics are the ones the AppOptics Kubernetes
+// integration reports, tagged with the namespace, pod_name and labels of the pod.
+var dashboardTemplates = map[string][]workloadChart{
+	"default": {
+		{"CPU usage", "kubernetes.pod.cpu.usage"},
+		{"Memory usage", "kubernetes.pod.memory.usage"},
+		{"Network received", "kubernetes.pod.network.rx_bytes"},
+		{"Network transmitted", "kubernetes.pod.network.tx_bytes"},
+		{"Container restarts", "kubernetes.pod.container.restarts"},
+	},
+	"resources": {
+		{"CPU usage", "kubernetes.pod.cpu.usage"},
+		{"Memory usage", "kubernetes.pod.memory.usage"},
+	},
+}
+
+// DashboardTemplates returns the names of the dashboard templates, sorted
+func DashboardTemplates() []string {
+	var names []string
+	for name := range dashboardTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WorkloadDashboard renders the spec.data of the dashboard a template generates for a workload.
+// Every chart is scoped to the workload's pods and has a line per pod.
+func WorkloadDashboard(template string, workload Workload) (string, error) {
+	charts, ok := dashboardTemplates[template]
+	if !ok {
+		return "", NewPermanentError(fmt.Errorf("unknown dashboard template %q, must be one of %s", template, strings.Join(DashboardTemplates(), ", ")))
+	}
+	tags, err := workload.PodTags()
+	if err != nil {
+		return "", err
+	}
+
+	// Rendered as YAML like a hand written spec, so it is parsed by ParseSpace like any other dashboard
+	var chartList []interface{}
+	for _, chart := range charts {
+		chartList = append(chartList, map[string]interface{}{
+			"name": chart.name,
+			"type": "line",
+			"streams": []interface{}{map[string]interface{}{
+				"metric":           chart.metric,
+				"group_function":   "sum",
+				"summary_function": "average",
+				"tags":             tags,
+			}},
+		})
+	}
+	dash := map[string]interface{}{
+		"name":   fmt.Sprintf("%s %s/%s", workload.Kind, workload.Namespace, workload.Name),
+		"charts": chartList,
+	}
+
+	data, err := yaml.Marshal(dash)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// BaselineThresholds are the thresholds of the baseline alerts generated for a workload, an alert
+// with a threshold of 0 is not generated
+type BaselineThresholds struct {
+	// Restarts is how many times the containers of one of the workload's pods restart between two
+	// measurements of the restart counter
+	Restarts int
+	// CPUThrottlingPercent is the share of CPU periods the workload's containers are throttled in
+	CPUThrottlingPercent int
+	// MemoryPercent is the share of their memory limit the workload's containers use
+	MemoryPercent int
+}
+
+// DefaultBaselineThresholds are used for the thresholds neither a policy nor the workload sets
+var DefaultBaselineThresholds = BaselineThresholds{Restarts: 3, CPUThrottlingPercent: 25, MemoryPercent: 90}
+
+// baselineAlert is an alert generated for every workload that opts in to the baseline alerts
+type baselineAlert struct {
+	suffix    string
+	summary   string
+	metric    string
+	threshold func(BaselineThresholds) int
+	// counter is set for metrics that only ever grow, whose increase is alerted on instead of their value
+	counter bool
+}
+
+// baselineAlerts are the baseline alerts by the suffix of their name. Like the dashboard templates
+// they use the metrics of the AppOptics Kubernetes integration.
+var baselineAlerts = []baselineAlert{
+	{"restarts", "container restarts increased by more than", "kubernetes.pod.container.restarts", func(t BaselineThresholds) int { return t.Restarts }, true},
+	{"cpu-throttling", "CPU throttled % above", "kubernetes.pod.cpu.throttled_percent", func(t BaselineThresholds) int { return t.CPUThrottlingPercent }, false},
+	{"memory", "memory used % of limit above", "kubernetes.pod.memory.limit_percent", func(t BaselineThresholds) int { return t.MemoryPercent }, false},
+}
+
+// BaselineAlertSuffixes returns the suffixes the names of the baseline alerts end with
+func BaselineAlertSuffixes() []string {
+	var suffixes []string
+	for _, alert := range baselineAlerts {
+		suffixes = append(suffixes, alert.suffix)
+	}
+	return suffixes
+}
+
+// WorkloadAlerts renders the spec.data of the baseline alerts of a workload, by the suffix of their
+// name. Each fires when the metric of any of the workload's pods stays above its threshold for 5 minutes,
+// except those on counters, which fire as soon as the counter grows by more than the threshold
+// between two measurements.
+func WorkloadAlerts(workload Workload, thresholds BaselineThresholds) (map[string]string, error) {
+	tags, err := workload.PodTags()
+	if err != nil {
+		return nil, err
+	}
+	alerts := map[string]string{}
+	for _, baseline := range baselineAlerts {
+		threshold := baseline.threshold(thresholds)
+		if threshold <= 0 {
+			continue
+		}
+		condition := map[string]interface{}{
+			"type":             "above",
+			"metric_name":      baseline.metric,
+			"threshold":        threshold,
+			"duration":         300,
+			"summary_function": "max",
+			"tags":             tags,
+		}
+		if baseline.counter {
+			// A counter stays above any threshold once reached, so its growth is compared instead
+			condition["summary_function"] = "derivative"
+			delete(condition, "duration")
+		}
+		alert := map[string]interface{}{
+			"name":          fmt.Sprintf("%s.%s.%s.%s", strings.ToLower(workload.Kind), workload.Namespace, workload.Name, baseline.suffix),
+			"description":   fmt.Sprintf("%s %s/%s %s %d", workload.Kind, workload.Namespace, workload.Name, baseline.summary, threshold),
+			"rearm_seconds": 600,
+			"conditions":    []interface{}{condition},
+		}
+		data, err := yaml.Marshal(alert)
+		if err != nil {
+			return nil, err
+		}
+		alerts[baseline.suffix] = string(data)
+	}
+	return alerts, nil
+}
@@ -0,0 +1,61 @@
+package appoptics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkloadDashboard(t *testing.T) {
+	data, err := WorkloadDashboard("default", Workload{Kind: "Deployment", Namespace: "shop", Name: "web", Selector: map[string]string{"app": "web"}})
+	assert.Equal(t, nil, err)
+
+	dash, err := ParseSpace(data)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(ValidateSpace(dash)))
+	assert.Equal(t, "Deployment shop/web", dash.Name)
+	assert.Equal(t, 5, len(dash.Charts))
+
+	names, err := MetricNames(Dashboard, data)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 5, len(names))
+
+	// The pods are found by the selector's labels, not by a name that other workloads may share
+	assert.Contains(t, data, "name: app")
+	assert.NotContains(t, data, "web-*")
+}
+
+func TestWorkloadDashboardWithoutMatchLabels(t *testing.T) {
+	_, err := WorkloadDashboard("default", Workload{Kind: "Deployment", Namespace: "shop", Name: "web"})
+	assert.NotEqual(t, nil, err)
+	assert.True(t, IsPermanentError(err))
+}
+
+func TestWorkloadDashboardUnknownTemplate(t *testing.T) {
+	_, err := WorkloadDashboard("missing", Workload{Kind: "Deployment", Namespace: "shop", Name: "web", Selector: map[string]string{"app": "web"}})
+	assert.NotEqual(t, nil, err)
+	assert.True(t, IsPermanentError(err))
+}
+
+func TestWorkloadAlerts(t *testing.T) {
+	thresholds := DefaultBaselineThresholds
+	thresholds.CPUThrottlingPercent = 0
+	alerts, err := WorkloadAlerts(Workload{Kind: "StatefulSet", Namespace: "shop", Name: "db", Selector: map[string]string{"app": "db"}}, thresholds)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(alerts))
+
+	alert, err := ParseAlert(alerts["memory"])
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(ValidateAlert(alert)))
+	assert.Equal(t, "statefulset.shop.db.memory", *alert.Name)
+	assert.Equal(t, 1, len(alert.Conditions))
+	assert.Equal(t, "kubernetes.pod.memory.limit_percent", *alert.Conditions[0].MetricName)
+
+	_, ok := alerts["cpu-throttling"]
+	assert.False(t, ok)
+
+	// Container restarts are a counter, so its growth is alerted on rather than its total
+	alert, err = ParseAlert(alerts["restarts"])
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "derivative", *alert.Conditions[0].SummaryFunction)
+}
@@ -14,6 +14,9 @@ const (
 	Dashboard = "dashboard"
 	Alert     = "alert"
 	Service   = "service"
+	Metric    = "metric"
+	// CompositeMetric matches the lowercased controller kind, composite metrics are stored as metrics
+	CompositeMetric = "compositemetric"
 )
 
 func CheckIfErrorIsAppOpticsNotFoundError(err error, kind string, id int) bool {
@@ -32,6 +35,66 @@ func CheckIfErrorIsAppOpticsNotFoundError(err error, kind string, id int) bool {
 	return false
 }
 
+// CheckIfErrorIsAppOpticsValidationError reports whether AppOptics rejected the request because of
+// invalid parameters, which will keep failing until the spec is changed
+func CheckIfErrorIsAppOpticsValidationError(err error) bool {
+	if errorResponse, ok := err.(*aoApi.ErrorResponse); ok {
+		if errorObj, ok := errorResponse.Errors.(map[string]interface{}); ok {
+			_, invalidParams := errorObj["params"]
+			return invalidParams
+		}
+	}
+	return false
+}
+
+// PermanentError wraps errors that retrying will not fix, such as a spec.data that cannot be parsed
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the error that cannot be fixed by retrying
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+func NewPermanentError(err error) error {
+	return &PermanentError{Err: err}
+}
+
+// IsPermanentError reports whether err, or any error it wraps, will keep happening until the resource
+// spec changes
+func IsPermanentError(err error) bool {
+	for err != nil {
+		if _, ok := err.(*PermanentError); ok {
+			return true
+		}
+		if CheckIfErrorIsAppOpticsValidationError(err) {
+			return true
+		}
+		wrapper, ok := err.(interface{ Unwrap() error })
+		if !ok {
+			return false
+		}
+		err = wrapper.Unwrap()
+	}
+	return false
+}
+
+// stringValue dereferences the optional strings used throughout the AppOptics API types
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// Hash is how desired and remote AppOptics objects are compared between syncs. It is the sha1 of the
+// object's JSON encoding, whose field order is fixed and map keys are sorted. Hash the parsed object
+// rather than the spec, so reformatting the spec data or moving the secret is not a change.
 func Hash(s interface{}) ([]byte, error) {
 	byteArr, err := json.Marshal(s)
 	if err != nil {
@@ -0,0 +1,51 @@
+package appoptics
+
+import (
+	"errors"
+	"testing"
+
+	aoApi "github.com/appoptics/appoptics-api-go"
+	"github.com/solarwinds/appoptics-kubernetes-controller/pkg/apis/appoptics-kubernetes-controller/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInvalidSpecDataIsPermanentError(t *testing.T) {
+	data := `name: [unclosed`
+
+	for _, kind := range []string{Dashboard, Service, Alert} {
+		td := v1.TokenAndDataSpec{Namespace: "Default", Data: data, Secret: "blah"}
+		_, err := aoc.Sync(td, &v1.Status{}, kind, SyncContext{Services: NewMockLister()})
+		assert.NotEqual(t, nil, err)
+		assert.True(t, IsPermanentError(err), kind)
+	}
+}
+
+func TestAppOpticsValidationErrorIsPermanentError(t *testing.T) {
+	err := &aoApi.ErrorResponse{Errors: map[string]interface{}{"params": map[string]interface{}{"name": []interface{}{"is not present"}}}}
+	assert.True(t, IsPermanentError(err))
+}
+
+func TestTransientErrorsAreNotPermanentErrors(t *testing.T) {
+	err := &aoApi.ErrorResponse{Errors: map[string]interface{}{"request": []interface{}{"Internal Server Error"}}}
+	assert.False(t, IsPermanentError(err))
+	assert.False(t, IsPermanentError(errors.New("connection refused")))
+}
+
+// wrappedError stands in for errors that wrap another, such as those made with fmt.Errorf and %w
+type wrappedError struct {
+	err error
+}
+
+func (e wrappedError) Error() string {
+	return "wrapped: " + e.err.Error()
+}
+
+func (e wrappedError) Unwrap() error {
+	return e.err
+}
+
+func TestWrappedPermanentErrorIsPermanentError(t *testing.T) {
+	err := wrappedError{wrappedError{NewPermanentError(errors.New("bad spec"))}}
+	assert.True(t, IsPermanentError(err))
+	assert.False(t, IsPermanentError(wrappedError{errors.New("connection refused")}))
+}
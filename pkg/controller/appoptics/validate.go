@@ -0,0 +1,152 @@
+package appoptics
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+
+	aoApi "github.com/appoptics/appoptics-api-go"
+)
+
+var alertConditionTypes = map[string]bool{
+	"above":  true,
+	"below":  true,
+	"absent": true,
+}
+
+// ValidateSpace checks a decoded dashboard for problems AppOptics would reject or silently mangle
+func ValidateSpace(dash CustomSpace) []error {
+	var problems []error
+	if dash.Name == "" {
+		problems = append(problems, fmt.Errorf("name is required"))
+	}
+	for i, chart := range dash.Charts {
+		if chart == nil || stringValue(chart.Name) == "" {
+			problems = append(problems, fmt.Errorf("charts[%d]: name is required", i))
+		}
+	}
+	return problems
+}
+
+// ValidateAlert checks a decoded alert for problems AppOptics would reject
+func ValidateAlert(alert aoApi.Alert) []error {
+	var problems []error
+	if stringValue(alert.Name) == "" {
+		problems = append(problems, fmt.Errorf("name is required"))
+	}
+	for i, condition := range alert.Conditions {
+		if !alertConditionTypes[stringValue(condition.Type)] {
+			problems = append(problems, fmt.Errorf("conditions[%d]: type %q must be one of above, below or absent", i, stringValue(condition.Type)))
+		}
+		if stringValue(condition.MetricName) == "" {
+			problems = append(problems, fmt.Errorf("conditions[%d]: metric_name is required", i))
+		}
+	}
+	if _, err := AlertServiceNames(alert); err != nil {
+		problems = append(problems, err)
+	}
+	return problems
+}
+
+// serviceSetting is a setting a service type requires, check validates its format when it is set
+type serviceSetting struct {
+	name  string
+	check func(value string) error
+}
+
+// serviceTypeSettings holds the settings each service type requires. Other types are passed to
+// AppOptics unchecked.
+var serviceTypeSettings = map[string][]serviceSetting{
+	"mail":      {{"addresses", checkMailAddresses}},
+	"slack":     {{"url", checkURL}},
+	"pagerduty": {{"service_key", nil}},
+	"webhook":   {{"url", checkURL}},
+}
+
+// ValidateService checks a decoded service for problems AppOptics would reject. Settings read from
+// Secrets that were redacted are only checked for presence. Setting values are never part of the
+// problems, they may be secret.
+func ValidateService(service aoApi.Service) []error {
+	var problems []error
+	if stringValue(service.Type) == "" {
+		problems = append(problems, fmt.Errorf("type is required"))
+	}
+	if stringValue(service.Title) == "" {
+		problems = append(problems, fmt.Errorf("title is required"))
+	}
+	for _, setting := range serviceTypeSettings[stringValue(service.Type)] {
+		value := strings.TrimSpace(service.Settings[setting.name])
+		if value == "" {
+			problems = append(problems, fmt.Errorf("settings.%s is required for %s services", setting.name, stringValue(service.Type)))
+			continue
+		}
+		if setting.check == nil || isRedactedSecretKeyRef(value) {
+			continue
+		}
+		if err := setting.check(value); err != nil {
+			problems = append(problems, fmt.Errorf("settings.%s %v", setting.name, err))
+		}
+	}
+	return problems
+}
+
+// checkMailAddresses accepts a comma separated list of email addresses
+func checkMailAddresses(value string) error {
+	for i, address := range strings.Split(value, ",") {
+		if _, err := mail.ParseAddress(strings.TrimSpace(address)); err != nil {
+			return fmt.Errorf("entry %d is not an email address", i+1)
+		}
+	}
+	return nil
+}
+
+// checkURL accepts absolute http and https URLs
+func checkURL(value string) error {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("must be an absolute http or https URL")
+	}
+	return nil
+}
+
+var metricTypes = map[string]bool{
+	"gauge":     true,
+	"composite": true,
+}
+
+// ValidateMetric checks a decoded metric for problems AppOptics would reject
+func ValidateMetric(metric aoApi.Metric) []error {
+	var problems []error
+	if !metricTypes[metric.Type] {
+		problems = append(problems, fmt.Errorf("type %q must be gauge or composite", metric.Type))
+	}
+	if metric.Type == "composite" && metric.Composite == "" {
+		problems = append(problems, fmt.Errorf("composite is required for composite metrics"))
+	}
+	if metric.Type != "composite" && metric.Composite != "" {
+		problems = append(problems, fmt.Errorf("composite is only allowed for composite metrics"))
+	}
+	return problems
+}
+
+// AlertServiceNames returns the AppOpticsService names listed in the alert's attributes.services
+func AlertServiceNames(alert aoApi.Alert) ([]string, error) {
+	services, ok := alert.Attributes["services"]
+	if !ok {
+		return nil, nil
+	}
+	list, ok := services.([]interface{})
+	if !ok {
+		return nil, NewPermanentError(fmt.Errorf("attributes.services must be a list of AppOpticsService names"))
+	}
+	var names []string
+	for _, serviceObj := range list {
+		name, ok := serviceObj.(string)
+		if !ok {
+			return nil, NewPermanentError(fmt.Errorf("attributes.services must be a list of AppOpticsService names"))
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
@@ -1,21 +1,31 @@
 package controller
 
 import (
+	"context"
 	"fmt"
+	"reflect"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
+	v12 "github.com/solarwinds/appoptics-kubernetes-controller/pkg/apis/appoptics-kubernetes-controller/v1"
 	clientset "github.com/solarwinds/appoptics-kubernetes-controller/pkg/client/clientset/versioned"
 	"github.com/solarwinds/appoptics-kubernetes-controller/pkg/client/clientset/versioned/scheme"
 	aoscheme "github.com/solarwinds/appoptics-kubernetes-controller/pkg/client/clientset/versioned/scheme"
 	informers "github.com/solarwinds/appoptics-kubernetes-controller/pkg/client/informers/externalversions"
 	listers "github.com/solarwinds/appoptics-kubernetes-controller/pkg/client/listers/appoptics-kubernetes-controller/v1"
+	"github.com/solarwinds/appoptics-kubernetes-controller/pkg/config"
+	"golang.org/x/time/rate"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
+	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	appslisters "k8s.io/client-go/listers/apps/v1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
@@ -34,32 +44,96 @@ type addFinalizer bool
 type Controller struct {
 	kubeclientset   kubernetes.Interface
 	aoclientset     clientset.Interface
-	cachesSynced    []cache.InformerSynced
+	informers       []*namespacedInformer
+	informerResync  time.Duration
+	scope           NamespaceScope
 	dashboardLister listers.AppOpticsDashboardLister
 	serviceLister   listers.AppOpticsServiceLister
+	serviceIndexer  cache.Indexer
 	alertLister     listers.AppOpticsAlertLister
+	alertIndexer    cache.Indexer
+	metricLister    listers.AppOpticsMetricLister
+	compositeLister listers.AppOpticsCompositeMetricLister
+	windowLister    listers.AppOpticsMaintenanceWindowLister
+	deployLister    appslisters.DeploymentLister
+	statefulLister  appslisters.StatefulSetLister
+	policyLister    listers.AppOpticsAlertPolicyLister
 	workqueue       workqueue.RateLimitingInterface
 	recorder        record.EventRecorder
-	resyncTime      int64
+	// limiter bounds how many keys the workers sync, whether they were queued by an event, a resync
+	// or a retry
+	limiter *rate.Limiter
+
+	// cfg is the configuration, whose reloadable fields change while the controller runs
+	cfg     config.Config
+	cfgLock sync.RWMutex
+	// clusterID marks the AppOptics objects this controller instance owns
+	clusterID string
+
+	// forced counts the requests to sync keys on their next successful run even if they were synced
+	// within the resync period, so a request made during a sync is not cleared by it
+	forced     map[string]int
+	forcedLock sync.Mutex
+
+	// serverSideApply is set when the API server supports server-side apply, checked once at startup
+	serverSideApply bool
+
+	// orphans holds the kind/ID of the orphaned objects the last garbage collection sweep found
+	orphans map[string]bool
+	// sweptSecrets holds the namespace/name of the Secrets whose accounts earlier sweeps went through,
+	// so an account is still swept once the last resource using it is gone
+	sweptSecrets map[string]bool
+
+	// watched holds the informers of every namespace in scope, by namespace
+	watched     map[string]*namespaceWatch
+	watchedLock sync.Mutex
 }
 
 // NewController returns a new controller
 func NewController(
 	kubeclientset kubernetes.Interface,
 	aoclientset clientset.Interface,
-	aoInformerFactory informers.SharedInformerFactory,
 	controllerAgentName string,
-	resyncTime int64) *Controller {
-
-	var cachesSynced []cache.InformerSynced
-	dashboardInformer := aoInformerFactory.Appoptics().V1().AppOpticsDashboards()
-	cachesSynced = append(cachesSynced, dashboardInformer.Informer().HasSynced)
-
-	serviceInformer := aoInformerFactory.Appoptics().V1().AppOpticsServices()
-	cachesSynced = append(cachesSynced, serviceInformer.Informer().HasSynced)
-
-	alertInformer := aoInformerFactory.Appoptics().V1().AppOpticsAlerts()
-	cachesSynced = append(cachesSynced, alertInformer.Informer().HasSynced)
+	cfg config.Config,
+	scope NamespaceScope) *Controller {
+
+	// Every kind is watched in each namespace in scope, and read from one indexer across them
+	dashboardInformer := newNamespacedInformer(func(ao informers.SharedInformerFactory, kube kubeinformers.SharedInformerFactory, namespace string) cache.SharedIndexInformer {
+		return ao.Appoptics().V1().AppOpticsDashboards().Informer()
+	}, nil)
+	serviceInformer := newNamespacedInformer(func(ao informers.SharedInformerFactory, kube kubeinformers.SharedInformerFactory, namespace string) cache.SharedIndexInformer {
+		return ao.Appoptics().V1().AppOpticsServices().Informer()
+	}, cache.Indexers{serviceSecretIndex: serviceSecretIndexFunc})
+	alertInformer := newNamespacedInformer(func(ao informers.SharedInformerFactory, kube kubeinformers.SharedInformerFactory, namespace string) cache.SharedIndexInformer {
+		return ao.Appoptics().V1().AppOpticsAlerts().Informer()
+	}, cache.Indexers{serviceRefIndex: alertServiceRefIndexFunc})
+	metricInformer := newNamespacedInformer(func(ao informers.SharedInformerFactory, kube kubeinformers.SharedInformerFactory, namespace string) cache.SharedIndexInformer {
+		return ao.Appoptics().V1().AppOpticsMetrics().Informer()
+	}, nil)
+	compositeInformer := newNamespacedInformer(func(ao informers.SharedInformerFactory, kube kubeinformers.SharedInformerFactory, namespace string) cache.SharedIndexInformer {
+		return ao.Appoptics().V1().AppOpticsCompositeMetrics().Informer()
+	}, nil)
+	maintenanceWindowInformer := newNamespacedInformer(func(ao informers.SharedInformerFactory, kube kubeinformers.SharedInformerFactory, namespace string) cache.SharedIndexInformer {
+		return ao.Appoptics().V1().AppOpticsMaintenanceWindows().Informer()
+	}, nil)
+	alertPolicyInformer := newNamespacedInformer(func(ao informers.SharedInformerFactory, kube kubeinformers.SharedInformerFactory, namespace string) cache.SharedIndexInformer {
+		return ao.Appoptics().V1().AppOpticsAlertPolicies().Informer()
+	}, nil)
+	deploymentInformer := newNamespacedInformer(func(ao informers.SharedInformerFactory, kube kubeinformers.SharedInformerFactory, namespace string) cache.SharedIndexInformer {
+		return kube.Apps().V1().Deployments().Informer()
+	}, nil)
+	statefulSetInformer := newNamespacedInformer(func(ao informers.SharedInformerFactory, kube kubeinformers.SharedInformerFactory, namespace string) cache.SharedIndexInformer {
+		return kube.Apps().V1().StatefulSets().Informer()
+	}, nil)
+	// Only the metadata of Secrets is cached, it is enough to tell when one changes
+	secretInformer := newNamespacedInformer(func(ao informers.SharedInformerFactory, kube kubeinformers.SharedInformerFactory, namespace string) cache.SharedIndexInformer {
+		return kube.InformerFor(&corev1.Secret{}, func(client kubernetes.Interface, resync time.Duration) cache.SharedIndexInformer {
+			return newSecretMetadataInformer(client, namespace, resync)
+		})
+	}, nil)
+	namespacedInformers := []*namespacedInformer{dashboardInformer, serviceInformer, alertInformer,
+		metricInformer, compositeInformer, maintenanceWindowInformer, alertPolicyInformer, deploymentInformer,
+		statefulSetInformer, secretInformer}
 
 	aoscheme.AddToScheme(scheme.Scheme)
 
@@ -73,40 +147,203 @@ func NewController(
 	controller := &Controller{
 		kubeclientset:   kubeclientset,
 		aoclientset:     aoclientset,
-		cachesSynced:    cachesSynced,
-		dashboardLister: dashboardInformer.Lister(),
-		serviceLister:   serviceInformer.Lister(),
-		alertLister:     alertInformer.Lister(),
-		workqueue:       workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "AppOptics"),
+		informers:       namespacedInformers,
+		informerResync:  cfg.Resync.Duration,
+		scope:           scope,
+		watched:         map[string]*namespaceWatch{},
+		dashboardLister: listers.NewAppOpticsDashboardLister(dashboardInformer.GetIndexer()),
+		serviceLister:   listers.NewAppOpticsServiceLister(serviceInformer.GetIndexer()),
+		serviceIndexer:  serviceInformer.GetIndexer(),
+		alertLister:     listers.NewAppOpticsAlertLister(alertInformer.GetIndexer()),
+		alertIndexer:    alertInformer.GetIndexer(),
+		metricLister:    listers.NewAppOpticsMetricLister(metricInformer.GetIndexer()),
+		compositeLister: listers.NewAppOpticsCompositeMetricLister(compositeInformer.GetIndexer()),
+		windowLister:    listers.NewAppOpticsMaintenanceWindowLister(maintenanceWindowInformer.GetIndexer()),
+		deployLister:    appslisters.NewDeploymentLister(deploymentInformer.GetIndexer()),
+		statefulLister:  appslisters.NewStatefulSetLister(statefulSetInformer.GetIndexer()),
+		policyLister:    listers.NewAppOpticsAlertPolicyLister(alertPolicyInformer.GetIndexer()),
+		workqueue:       workqueue.NewNamedRateLimitingQueue(newRateLimiter(cfg), "AppOptics"),
 		recorder:        recorder,
-		resyncTime:      resyncTime,
+		limiter:         rate.NewLimiter(rate.Limit(cfg.RateLimit.QPS), cfg.RateLimit.Burst),
+		cfg:             cfg,
+		forced:          map[string]int{},
 	}
 
 	glog.Info("Setting up event handlers")
-	// we add handlers only for the Dashboards/Services/Alerts! we don't want to control pods and things like that
+	// we add handlers only for the Dashboards/Services/Alerts/Metrics/CompositeMetrics! we don't want to control pods and things like that
 	// just our resource
-	dashboardInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+	dashboardInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: func(new interface{}) {
 			controller.enqueue(new, Dashboard)
 		}, UpdateFunc: func(old, new interface{}) {
 			controller.enqueue(new, Dashboard)
+			controller.enqueueOwningWorkload(new.(*v12.AppOpticsDashboard))
+		}, DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			dashboard, ok := obj.(*v12.AppOpticsDashboard)
+			if !ok {
+				runtime.HandleError(fmt.Errorf("expected AppOpticsDashboard in delete event but got %#v", obj))
+				return
+			}
+			controller.enqueueOwningWorkload(dashboard)
 		},
 	})
 	//
-	serviceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+	serviceInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: func(new interface{}) {
 			controller.enqueue(new, Service)
 		}, UpdateFunc: func(old, new interface{}) {
 			controller.enqueue(new, Service)
+			// Alerts are associated by service ID, so sync them again once the service is ready or
+			// has been recreated in AppOptics
+			oldService := old.(*v12.AppOpticsService)
+			newService := new.(*v12.AppOpticsService)
+			if oldService.Status.ID != newService.Status.ID {
+				controller.enqueueReferencingAlerts(newService.Namespace, newService.Name)
+			}
+		}, DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			service, ok := obj.(*v12.AppOpticsService)
+			if !ok {
+				runtime.HandleError(fmt.Errorf("expected AppOpticsService in delete event but got %#v", obj))
+				return
+			}
+			controller.enqueueReferencingAlerts(service.Namespace, service.Name)
 		},
 	})
 
-	alertInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+	alertInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: func(new interface{}) {
 			controller.enqueue(new, Alert)
 		},
 		UpdateFunc: func(old, new interface{}) {
 			controller.enqueue(new, Alert)
+			controller.enqueueOwningWorkload(new.(*v12.AppOpticsAlert))
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			alert, ok := obj.(*v12.AppOpticsAlert)
+			if !ok {
+				runtime.HandleError(fmt.Errorf("expected AppOpticsAlert in delete event but got %#v", obj))
+				return
+			}
+			controller.enqueueOwningWorkload(alert)
+		},
+	})
+
+	metricInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(new interface{}) {
+			controller.enqueue(new, Metric)
+		},
+		UpdateFunc: func(old, new interface{}) {
+			controller.enqueue(new, Metric)
+		},
+	})
+
+	compositeInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(new interface{}) {
+			controller.enqueue(new, CompositeMetric)
+		},
+		UpdateFunc: func(old, new interface{}) {
+			controller.enqueue(new, CompositeMetric)
+		},
+	})
+
+	// Services read settings from Secrets when they are synced, so sync them again when one changes.
+	// The cache holds no data to compare, any new version of the Secret counts. Deleting a Secret
+	// leaves the service as it is in AppOptics.
+	secretInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(new interface{}) {
+			secret := new.(*corev1.Secret)
+			controller.enqueueServicesUsingSecret(secret.Namespace, secret.Name)
+		},
+		UpdateFunc: func(old, new interface{}) {
+			oldSecret := old.(*corev1.Secret)
+			newSecret := new.(*corev1.Secret)
+			if oldSecret.ResourceVersion != newSecret.ResourceVersion {
+				controller.enqueueServicesUsingSecret(newSecret.Namespace, newSecret.Name)
+			}
+		},
+	})
+
+	// Workloads are only watched for their annotations, the resources generated for them are removed
+	// by the garbage collector when they are deleted
+	deploymentInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(new interface{}) {
+			controller.enqueue(new, Deployment)
+		},
+		UpdateFunc: func(old, new interface{}) {
+			if !reflect.DeepEqual(old.(*appsv1.Deployment).Annotations, new.(*appsv1.Deployment).Annotations) {
+				controller.enqueue(new, Deployment)
+			}
+		},
+	})
+
+	statefulSetInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(new interface{}) {
+			controller.enqueue(new, StatefulSet)
+		},
+		UpdateFunc: func(old, new interface{}) {
+			if !reflect.DeepEqual(old.(*appsv1.StatefulSet).Annotations, new.(*appsv1.StatefulSet).Annotations) {
+				controller.enqueue(new, StatefulSet)
+			}
+		},
+	})
+
+	alertPolicyInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(new interface{}) {
+			controller.enqueuePolicyWorkloads(new.(*v12.AppOpticsAlertPolicy))
+		},
+		UpdateFunc: func(old, new interface{}) {
+			oldPolicy := old.(*v12.AppOpticsAlertPolicy)
+			newPolicy := new.(*v12.AppOpticsAlertPolicy)
+			if !reflect.DeepEqual(oldPolicy.Spec, newPolicy.Spec) {
+				controller.enqueuePolicyWorkloads(newPolicy)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			policy, ok := obj.(*v12.AppOpticsAlertPolicy)
+			if !ok {
+				runtime.HandleError(fmt.Errorf("expected AppOpticsAlertPolicy in delete event but got %#v", obj))
+				return
+			}
+			controller.enqueuePolicyWorkloads(policy)
+		},
+	})
+
+	// Opening and closing maintenance windows is handled by checkMaintenanceWindows, these only
+	// handle changes to the windows themselves
+	maintenanceWindowInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(new interface{}) {
+			controller.enqueueSelectedAlerts(new.(*v12.AppOpticsMaintenanceWindow))
+		},
+		UpdateFunc: func(old, new interface{}) {
+			oldWindow := old.(*v12.AppOpticsMaintenanceWindow)
+			newWindow := new.(*v12.AppOpticsMaintenanceWindow)
+			if !reflect.DeepEqual(oldWindow.Spec, newWindow.Spec) {
+				controller.enqueueSelectedAlerts(oldWindow)
+				controller.enqueueSelectedAlerts(newWindow)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			window, ok := obj.(*v12.AppOpticsMaintenanceWindow)
+			if !ok {
+				runtime.HandleError(fmt.Errorf("expected AppOpticsMaintenanceWindow in delete event but got %#v", obj))
+				return
+			}
+			controller.enqueueSelectedAlerts(window)
 		},
 	})
 
@@ -119,8 +356,18 @@ func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) error {
 
 	glog.Info("Starting AppOptics controller")
 
+	if err := c.resolveClusterID(); err != nil {
+		return err
+	}
+	c.detectServerSideApply()
+
+	if err := c.startWatching(stopCh); err != nil {
+		return err
+	}
+	defer c.stopWatching()
+
 	glog.Info("Waiting for informer caches to sync")
-	if ok := cache.WaitForCacheSync(stopCh, c.cachesSynced...); !ok {
+	if ok := cache.WaitForCacheSync(stopCh, c.watchedSynced()...); !ok {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
 
@@ -129,6 +376,14 @@ func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) error {
 		go wait.Until(c.runWorker, time.Second, stopCh)
 	}
 
+	go wait.Until(c.checkMaintenanceWindows, maintenanceWindowInterval, stopCh)
+	if pollInterval := c.settings().AlertStateInterval.Duration; pollInterval > 0 {
+		go wait.Until(c.pollAlertStates, pollInterval, stopCh)
+	}
+	if gcInterval := c.settings().GarbageCollection.Interval.Duration; gcInterval > 0 {
+		go wait.Until(c.sweepOrphans, gcInterval, stopCh)
+	}
+
 	glog.Info("Started workers")
 	<-stopCh
 	glog.Info("Shutting down workers")
@@ -136,6 +391,48 @@ func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) error {
 	return nil
 }
 
+// resolveClusterID sets the ID marking the AppOptics objects this controller owns, from the
+// configuration or else the UID of the kube-system namespace, which lives as long as the cluster
+func (c *Controller) resolveClusterID() error {
+	if c.clusterID = c.settings().ClusterID; c.clusterID != "" {
+		return nil
+	}
+	namespace, err := c.kubeclientset.CoreV1().Namespaces().Get(metav1.NamespaceSystem, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to read the cluster ID from namespace %s: %v", metav1.NamespaceSystem, err)
+	}
+	c.clusterID = string(namespace.UID)
+	glog.Infof("Marking AppOptics objects with cluster ID %s", c.clusterID)
+	return nil
+}
+
+// newRateLimiter backs off the retries of a key exponentially. How many keys are synced overall is
+// bounded by the controller's limiter, as the queue only rate limits retries.
+func newRateLimiter(cfg config.Config) workqueue.RateLimiter {
+	return workqueue.NewItemExponentialFailureRateLimiter(cfg.Retry.BaseDelay.Duration, cfg.Retry.MaxDelay.Duration)
+}
+
+// settings returns the current configuration
+func (c *Controller) settings() config.Config {
+	c.cfgLock.RLock()
+	defer c.cfgLock.RUnlock()
+	return c.cfg
+}
+
+// Reload applies the reloadable fields of a new configuration, and warns about the changed fields
+// that need a restart
+func (c *Controller) Reload(next config.Config) {
+	c.cfgLock.Lock()
+	reloaded, restart := c.cfg.Reload(next)
+	c.cfg = reloaded
+	c.cfgLock.Unlock()
+
+	glog.Info("Reloaded configuration")
+	if len(restart) > 0 {
+		glog.Warningf("Configuration of %s changed, restart the controller to apply it", strings.Join(restart, ", "))
+	}
+}
+
 func (c *Controller) runWorker() {
 	for c.processNextWorkItem() {
 	}
@@ -157,10 +454,23 @@ func (c *Controller) processNextWorkItem() bool {
 			runtime.HandleError(fmt.Errorf("expected string in workqueue but got %#v", obj))
 			return nil
 		}
+		if err := c.limiter.Wait(context.Background()); err != nil {
+			c.workqueue.AddRateLimited(key)
+			return err
+		}
+		forced := c.forcedRequests(key)
 		if err := c.syncHandler(key); err != nil {
-			return fmt.Errorf("error syncing '%s': %s", key, err.Error())
+			maxRetries := c.settings().Retry.MaxRetries
+			if c.workqueue.NumRequeues(key) < maxRetries {
+				c.workqueue.AddRateLimited(key)
+				return fmt.Errorf("error syncing '%s', requeuing: %s", key, err.Error())
+			}
+			c.workqueue.Forget(obj)
+			c.clearForced(key, forced)
+			return fmt.Errorf("error syncing '%s', giving up after %d retries: %s", key, maxRetries, err.Error())
 		}
 		c.workqueue.Forget(obj)
+		c.clearForced(key, forced)
 		glog.Infof("Successfully synced '%s'", key)
 		return nil
 	}(obj)
@@ -182,7 +492,40 @@ func (c *Controller) enqueue(obj interface{}, kind string) {
 
 	key := fmt.Sprintf("%s/%s/%s", metaObj.GetNamespace(), kind, metaObj.GetName())
 
-	c.workqueue.AddRateLimited(key)
+	c.workqueue.Add(key)
+}
+
+// enqueueForced queues the resource to be synced on its next run, even if it was synced within the resync period
+func (c *Controller) enqueueForced(obj interface{}, kind string) {
+	metaObj, err := meta.Accessor(obj)
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+
+	key := fmt.Sprintf("%s/%s/%s", metaObj.GetNamespace(), kind, metaObj.GetName())
+
+	c.forcedLock.Lock()
+	c.forced[key]++
+	c.forcedLock.Unlock()
+	c.workqueue.Add(key)
+}
+
+// forcedRequests returns how many times the key was queued by enqueueForced since it last synced.
+// The requests stay until a sync succeeds, so a retry after a failure is not skipped by the resync period.
+func (c *Controller) forcedRequests(key string) int {
+	c.forcedLock.Lock()
+	defer c.forcedLock.Unlock()
+	return c.forced[key]
+}
+
+// clearForced forgets the requests counted before a successful sync, unless more were made meanwhile
+func (c *Controller) clearForced(key string, requests int) {
+	c.forcedLock.Lock()
+	defer c.forcedLock.Unlock()
+	if c.forced[key] == requests {
+		delete(c.forced, key)
+	}
 }
 
 func (c *Controller) SplitMetaNamespaceKey(key string) (namespace, kind string, name string, err error) {
@@ -199,16 +542,20 @@ func (c *Controller) SplitMetaNamespaceKey(key string) (namespace, kind string,
 	return "", "", "", fmt.Errorf("unexpected key format: %q", key)
 }
 
+// finalizers adds or removes the controller's finalizer, keeping those of anything else
 func (c *Controller) finalizers(spec *CommonAOResource, isAdd addFinalizer) {
+	if bool(isAdd) == hasFinalizer(spec) {
+		return
+	}
 	if isAdd {
-		if len(spec.Finalizers) != 1 || spec.Finalizers[0] != AppopticsFinalizer {
-			spec.Finalizers = []string{
-				AppopticsFinalizer,
-			}
-		}
-	} else {
-		if len(spec.Finalizers) != 0 {
-			spec.Finalizers = []string{}
+		spec.Finalizers = append(spec.Finalizers, AppopticsFinalizer)
+		return
+	}
+	finalizers := []string{}
+	for _, finalizer := range spec.Finalizers {
+		if finalizer != AppopticsFinalizer {
+			finalizers = append(finalizers, finalizer)
 		}
 	}
+	spec.Finalizers = finalizers
 }
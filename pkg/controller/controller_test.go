@@ -0,0 +1,102 @@
+package controller
+
+import (
+	v12 "github.com/solarwinds/appoptics-kubernetes-controller/pkg/apis/appoptics-kubernetes-controller/v1"
+	aofake "github.com/solarwinds/appoptics-kubernetes-controller/pkg/client/clientset/versioned/fake"
+	"github.com/solarwinds/appoptics-kubernetes-controller/pkg/config"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+// newTestController returns a controller whose clientsets and listers hold the objects, with events
+// recorded by a FakeRecorder. Its informers are never run, so handlers are only called by the tests.
+func newTestController(cfg config.Config, objects ...runtime.Object) *Controller {
+	var kubeObjects, aoObjects []runtime.Object
+	for _, object := range objects {
+		if isAppOpticsObject(object) {
+			aoObjects = append(aoObjects, object)
+		} else {
+			kubeObjects = append(kubeObjects, object)
+		}
+	}
+
+	c := NewController(kubefake.NewSimpleClientset(kubeObjects...), aofake.NewSimpleClientset(aoObjects...), "appoptics-controller-test", cfg, NamespaceScope{})
+	c.recorder = record.NewFakeRecorder(100)
+	for _, object := range objects {
+		if i := testInformerIndex(object); i >= 0 {
+			c.informers[i].GetIndexer().Add(object)
+		}
+	}
+	return c
+}
+
+// isAppOpticsObject reports whether the object is one of the controller's own resources, served by
+// the AppOptics clientset rather than the Kubernetes one
+func isAppOpticsObject(object runtime.Object) bool {
+	switch object.(type) {
+	case *v12.AppOpticsDashboard, *v12.AppOpticsService, *v12.AppOpticsAlert, *v12.AppOpticsMetric,
+		*v12.AppOpticsCompositeMetric, *v12.AppOpticsMaintenanceWindow, *v12.AppOpticsAlertPolicy:
+		return true
+	}
+	return false
+}
+
+// testInformerIndex returns the position in Controller.informers of the informer watching the
+// object's kind, in the order NewController creates them, or -1 for kinds without one
+func testInformerIndex(object runtime.Object) int {
+	switch object.(type) {
+	case *v12.AppOpticsDashboard:
+		return 0
+	case *v12.AppOpticsService:
+		return 1
+	case *v12.AppOpticsAlert:
+		return 2
+	case *v12.AppOpticsMetric:
+		return 3
+	case *v12.AppOpticsCompositeMetric:
+		return 4
+	case *v12.AppOpticsMaintenanceWindow:
+		return 5
+	case *v12.AppOpticsAlertPolicy:
+		return 6
+	case *appsv1.Deployment:
+		return 7
+	case *appsv1.StatefulSet:
+		return 8
+	case *corev1.Secret:
+		return 9
+	}
+	return -1
+}
+
+// queuedKeys empties the controller's work queue and returns the keys it held
+func queuedKeys(c *Controller) []string {
+	var keys []string
+	for c.workqueue.Len() > 0 {
+		key, _ := c.workqueue.Get()
+		keys = append(keys, key.(string))
+		c.workqueue.Forget(key)
+		c.workqueue.Done(key)
+	}
+	return keys
+}
+
+// testEvents returns the events recorded by the controller's FakeRecorder since it was last called
+func testEvents(c *Controller) []string {
+	recorder, ok := c.recorder.(*record.FakeRecorder)
+	if !ok {
+		recorder = c.recorder.(*testObjectRecorder).FakeRecorder
+	}
+	var events []string
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
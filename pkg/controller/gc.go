@@ -0,0 +1,216 @@
+package controller
+
+import (
+	"expvar"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	v12 "github.com/solarwinds/appoptics-kubernetes-controller/pkg/apis/appoptics-kubernetes-controller/v1"
+	"github.com/solarwinds/appoptics-kubernetes-controller/pkg/config"
+	"github.com/solarwinds/appoptics-kubernetes-controller/pkg/controller/appoptics"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/runtime"
+)
+
+const (
+	// OrphanFound is used as part of the Event 'reason' when the sweep finds an AppOptics object owned
+	// by this controller whose resource no longer exists
+	OrphanFound = "OrphanFound"
+
+	// OrphanDeleted is used as part of the Event 'reason' when the sweep deletes an orphaned object
+	OrphanDeleted = "OrphanDeleted"
+
+	// MessageOrphan is the message used for the Events of an orphaned object, on the Secret holding
+	// the token of its account
+	MessageOrphan = "%s %d %q has no %s %s/%s"
+)
+
+// The sweep's metrics, served at /debug/vars when a metrics address is set. Orphans are counted by kind.
+var (
+	gcOrphansFound   = expvar.NewMap("appoptics_gc_orphans_found")
+	gcOrphansDeleted = expvar.NewMap("appoptics_gc_orphans_deleted")
+	gcErrors         = expvar.NewInt("appoptics_gc_errors")
+	gcLastSweep      = expvar.NewInt("appoptics_gc_last_sweep_timestamp_seconds")
+)
+
+// orphanKinds maps the kinds of owned AppOptics objects to the kinds of their resources
+var orphanKinds = map[string]string{
+	appoptics.Dashboard: Dashboard,
+	appoptics.Service:   Service,
+	appoptics.Alert:     Alert,
+}
+
+// sweepOrphans looks through every account the watched resources use, or have used since the
+// controller started, and every account configured to be swept, for the spaces, services and alerts
+// this controller instance owns whose resource is gone, such as when a finalizer was removed by hand
+// or the resource was deleted while the controller was down. It deletes or only reports them, as
+// configured. An orphan is only reported the first time a sweep finds it.
+func (c *Controller) sweepOrphans() {
+	settings := c.settings()
+	found := map[string]bool{}
+	accounts, err := c.accountSecrets()
+	if err != nil {
+		gcErrors.Add(1)
+		runtime.HandleError(err)
+		return
+	}
+
+	for _, secret := range accounts {
+		aoc, err := c.GetCommunicator(secret)
+		if err != nil {
+			gcErrors.Add(1)
+			runtime.HandleError(err)
+			continue
+		}
+		objects, err := aoc.OwnedObjects(c.clusterID)
+		if err != nil {
+			gcErrors.Add(1)
+			runtime.HandleError(fmt.Errorf("error listing the AppOptics objects of secret %s/%s: %s", secret.Namespace, secret.Name, err.Error()))
+			continue
+		}
+
+		for _, object := range objects {
+			kind := orphanKinds[object.Kind]
+			if !c.isOrphan(kind, object) {
+				continue
+			}
+			orphan := fmt.Sprintf("%s/%d", kind, object.ID)
+			found[orphan] = true
+			message := fmt.Sprintf(MessageOrphan, kind, object.ID, object.Name, kind, object.Owner.Namespace, object.Owner.Name)
+			if settings.GarbageCollection.Mode != config.GarbageCollectionDelete || settings.DryRun {
+				if !c.orphans[orphan] {
+					gcOrphansFound.Add(kind, 1)
+					glog.Warningf("Orphaned AppOptics object: %s", message)
+					c.recorder.Event(secret, corev1.EventTypeWarning, OrphanFound, message)
+				}
+				continue
+			}
+			gcOrphansFound.Add(kind, 1)
+			// The communicator only deletes objects marked as owned by its resource
+			aoc.Owner = object.Owner
+			err = aoc.Remove(&v12.Status{ID: object.ID}, kind)
+			if err != nil {
+				gcErrors.Add(1)
+				runtime.HandleError(fmt.Errorf("error deleting orphaned %s %d: %s", kind, object.ID, err.Error()))
+				continue
+			}
+			gcOrphansDeleted.Add(kind, 1)
+			glog.Infof("Deleted orphaned AppOptics object: %s", message)
+			c.recorder.Event(secret, corev1.EventTypeNormal, OrphanDeleted, message)
+		}
+	}
+	c.orphans = found
+	gcLastSweep.Set(time.Now().Unix())
+}
+
+// accountSecrets returns one Secret for each AppOptics token used by the watched dashboards, services
+// and alerts, by the Secrets configured to be swept, or by the Secrets of earlier sweeps that still exist
+func (c *Controller) accountSecrets() ([]*corev1.Secret, error) {
+	// Secrets by namespace/name
+	names := map[string]bool{}
+	for _, name := range c.settings().GarbageCollection.Secrets {
+		names[name] = true
+	}
+	for name := range c.sweptSecrets {
+		names[name] = true
+	}
+	dashboards, err := c.dashboardLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, dashboard := range dashboards {
+		names[dashboard.Namespace+"/"+c.secretName(dashboard.Spec)] = true
+	}
+	services, err := c.serviceLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, service := range services {
+		names[service.Namespace+"/"+c.secretName(service.Spec)] = true
+	}
+	alerts, err := c.alertLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, alert := range alerts {
+		names[alert.Namespace+"/"+c.secretName(alert.Spec)] = true
+	}
+
+	var secrets []*corev1.Secret
+	tokens := map[string]bool{}
+	swept := map[string]bool{}
+	for name := range names {
+		parts := strings.SplitN(name, "/", 2)
+		secret, err := c.kubeclientset.CoreV1().Secrets(parts[0]).Get(parts[1], metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		swept[name] = true
+		token := string(secret.Data["token"])
+		if token == "" || tokens[token] {
+			continue
+		}
+		tokens[token] = true
+		secrets = append(secrets, secret)
+	}
+	c.sweptSecrets = swept
+	return secrets, nil
+}
+
+// isOrphan reports whether the resource an owned AppOptics object was created for is gone, or has
+// another object of its own. Objects of namespaces that are not watched, or whose informers have
+// not synced, are never orphans.
+func (c *Controller) isOrphan(kind string, object appoptics.OwnedObject) bool {
+	if kind == "" || !c.isWatched(object.Owner.Namespace) {
+		return false
+	}
+	aoResource, err := c.getResource(kind, object.Owner.Namespace, object.Owner.Name)
+	if errors.IsNotFound(err) {
+		return true
+	} else if err != nil {
+		runtime.HandleError(err)
+		return false
+	}
+	// A resource, or one recreated under the same name, only gives up an object once it has
+	// another of its own
+	if aoResource.Status.ID == 0 || aoResource.Status.ID == object.ID {
+		return false
+	}
+	// The object is a duplicate, made by an earlier sync of the resource or by an earlier resource
+	// of the same name. Spaces and services carry no UID telling them apart, so the resource is read
+	// again in case the cache is behind a sync that just recreated the object.
+	aoResource, err = c.readResource(kind, object.Owner.Namespace, object.Owner.Name)
+	if errors.IsNotFound(err) {
+		return true
+	} else if err != nil {
+		runtime.HandleError(err)
+		return false
+	}
+	return aoResource.Status.ID != 0 && aoResource.Status.ID != object.ID
+}
+
+// isWatched reports whether the namespace is watched and its informers have synced
+func (c *Controller) isWatched(namespace string) bool {
+	c.watchedLock.Lock()
+	defer c.watchedLock.Unlock()
+	watch, ok := c.watched[namespace]
+	if !ok {
+		watch, ok = c.watched[metav1.NamespaceAll]
+	}
+	if !ok {
+		return false
+	}
+	for _, synced := range watch.synced {
+		if !synced() {
+			return false
+		}
+	}
+	return true
+}
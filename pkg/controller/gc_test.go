@@ -0,0 +1,145 @@
+package controller
+
+import (
+	"testing"
+
+	v12 "github.com/solarwinds/appoptics-kubernetes-controller/pkg/apis/appoptics-kubernetes-controller/v1"
+	aofake "github.com/solarwinds/appoptics-kubernetes-controller/pkg/client/clientset/versioned/fake"
+	listers "github.com/solarwinds/appoptics-kubernetes-controller/pkg/client/listers/appoptics-kubernetes-controller/v1"
+	"github.com/solarwinds/appoptics-kubernetes-controller/pkg/config"
+	"github.com/solarwinds/appoptics-kubernetes-controller/pkg/controller/appoptics"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+)
+
+const testClusterID = "cluster"
+
+func newTestDashboard(name string, uid string, ID int) *v12.AppOpticsDashboard {
+	return &v12.AppOpticsDashboard{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: name, UID: types.UID(uid)},
+		Status:     v12.Status{ID: ID},
+	}
+}
+
+// newTestGCController returns a controller watching namespace team-a, whose informers have synced
+// when synced is set. The cache holds the cached dashboards, the API server the stored ones.
+func newTestGCController(synced bool, cached []*v12.AppOpticsDashboard, stored ...*v12.AppOpticsDashboard) *Controller {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, dashboard := range cached {
+		indexer.Add(dashboard)
+	}
+	clientset := aofake.NewSimpleClientset()
+	for _, dashboard := range stored {
+		clientset.AppopticsV1().AppOpticsDashboards(dashboard.Namespace).Create(dashboard)
+	}
+	return &Controller{
+		aoclientset:     clientset,
+		dashboardLister: listers.NewAppOpticsDashboardLister(indexer),
+		watched: map[string]*namespaceWatch{
+			"team-a": {synced: []cache.InformerSynced{func() bool { return synced }}},
+		},
+	}
+}
+
+func ownedSpace(ID int, name string, uid string) appoptics.OwnedObject {
+	return appoptics.OwnedObject{
+		Kind:  appoptics.Dashboard,
+		ID:    ID,
+		Name:  name,
+		Owner: appoptics.Ownership{ClusterID: testClusterID, Namespace: "team-a", Name: name, UID: uid},
+	}
+}
+
+func TestIsOrphanResourceGone(t *testing.T) {
+	c := newTestGCController(true, nil)
+	assert.True(t, c.isOrphan(Dashboard, ownedSpace(1, "web", "")))
+}
+
+func TestIsOrphanUnwatchedNamespace(t *testing.T) {
+	c := newTestGCController(true, nil)
+	object := ownedSpace(1, "web", "")
+	object.Owner.Namespace = "team-b"
+	assert.False(t, c.isOrphan(Dashboard, object))
+	assert.False(t, c.isOrphan("", ownedSpace(1, "web", "")))
+}
+
+func TestIsOrphanUnsyncedCaches(t *testing.T) {
+	c := newTestGCController(false, nil)
+	assert.False(t, c.isOrphan(Dashboard, ownedSpace(1, "web", "")))
+}
+
+func TestIsOrphanSyncedObject(t *testing.T) {
+	dashboard := newTestDashboard("web", "uid-1", 1)
+	c := newTestGCController(true, []*v12.AppOpticsDashboard{dashboard}, dashboard)
+	assert.False(t, c.isOrphan(Dashboard, ownedSpace(1, "web", "uid-1")))
+}
+
+// Tests that the object of a deleted resource is only collected once the resource recreated under
+// the same name has an object of its own
+func TestIsOrphanRecreatedResource(t *testing.T) {
+	recreated := newTestDashboard("web", "uid-2", 0)
+	c := newTestGCController(true, []*v12.AppOpticsDashboard{recreated}, recreated)
+	assert.False(t, c.isOrphan(Dashboard, ownedSpace(1, "web", "uid-1")))
+
+	recreated = newTestDashboard("web", "uid-2", 2)
+	c = newTestGCController(true, []*v12.AppOpticsDashboard{recreated}, recreated)
+	assert.True(t, c.isOrphan(Dashboard, ownedSpace(1, "web", "uid-1")))
+}
+
+// Tests that a duplicate made by the same resource is collected although spaces carry no UID
+func TestIsOrphanDuplicateOfSameResource(t *testing.T) {
+	dashboard := newTestDashboard("web", "uid-1", 2)
+	c := newTestGCController(true, []*v12.AppOpticsDashboard{dashboard}, dashboard)
+	assert.True(t, c.isOrphan(Dashboard, ownedSpace(1, "web", "")))
+}
+
+// Tests that an object the cache does not know the resource has yet is not collected
+func TestIsOrphanStaleCache(t *testing.T) {
+	cached := newTestDashboard("web", "uid-1", 1)
+	stored := newTestDashboard("web", "uid-1", 2)
+	c := newTestGCController(true, []*v12.AppOpticsDashboard{cached}, stored)
+	assert.False(t, c.isOrphan(Dashboard, ownedSpace(2, "web", "")))
+}
+
+func newTestTokenSecret(namespace, name, token string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Data:       map[string][]byte{"token": []byte(token)},
+	}
+}
+
+func secretNames(secrets []*corev1.Secret) []string {
+	var names []string
+	for _, secret := range secrets {
+		names = append(names, secret.Namespace+"/"+secret.Name)
+	}
+	return names
+}
+
+// Tests that the account of a resource is still swept once the resource is gone, for as long as its
+// Secret exists, and that configured Secrets are always swept
+func TestAccountSecretsRemembersEarlierSweeps(t *testing.T) {
+	cfg := config.Default()
+	cfg.GarbageCollection.Secrets = []string{"ops/appoptics"}
+	dashboard := newTestDashboard("web", "uid-1", 1)
+	c := newTestController(cfg, dashboard, newTestTokenSecret("team-a", "appoptics", "a"),
+		newTestTokenSecret("ops", "appoptics", "b"), newTestTokenSecret("team-b", "appoptics", "c"))
+
+	secrets, err := c.accountSecrets()
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"team-a/appoptics", "ops/appoptics"}, secretNames(secrets))
+
+	c.informers[testInformerIndex(dashboard)].GetIndexer().Delete(dashboard)
+	secrets, err = c.accountSecrets()
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"team-a/appoptics", "ops/appoptics"}, secretNames(secrets))
+
+	assert.Nil(t, c.kubeclientset.CoreV1().Secrets("team-a").Delete("appoptics", &metav1.DeleteOptions{}))
+	secrets, err = c.accountSecrets()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"ops/appoptics"}, secretNames(secrets))
+	assert.False(t, c.sweptSecrets["team-a/appoptics"])
+}
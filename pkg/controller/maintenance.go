@@ -0,0 +1,201 @@
+package controller
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	v12 "github.com/solarwinds/appoptics-kubernetes-controller/pkg/apis/appoptics-kubernetes-controller/v1"
+	"github.com/solarwinds/appoptics-kubernetes-controller/pkg/schedule"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// maintenanceWindowInterval is how often maintenance windows are checked for opening or closing
+	maintenanceWindowInterval = 30 * time.Second
+
+	// maxScheduledWindowDuration bounds how long a scheduled maintenance window stays open
+	maxScheduledWindowDuration = 7 * 24 * time.Hour
+
+	// MaintenanceWindowOpened is used as part of the Event 'reason' when a maintenance window opens
+	MaintenanceWindowOpened = "MaintenanceWindowOpened"
+
+	// MaintenanceWindowClosed is used as part of the Event 'reason' when a maintenance window closes
+	MaintenanceWindowClosed = "MaintenanceWindowClosed"
+
+	// MessageMaintenanceWindow is the message used for Events when a maintenance window opens or closes
+	MessageMaintenanceWindow = "Maintenance window %s"
+)
+
+// maintenanceWindowOpen reports whether a maintenance window with the given spec is open at now
+func maintenanceWindowOpen(spec v12.MaintenanceWindowSpec, now time.Time) (bool, error) {
+	if spec.Schedule == "" && spec.End == nil {
+		return false, fmt.Errorf("either end or schedule is required")
+	}
+	if spec.Start != nil && now.Before(spec.Start.Time) {
+		return false, nil
+	}
+	if spec.End != nil && !now.Before(spec.End.Time) {
+		return false, nil
+	}
+	if spec.Schedule == "" {
+		return true, nil
+	}
+
+	if spec.Duration.Duration <= 0 || spec.Duration.Duration > maxScheduledWindowDuration {
+		return false, fmt.Errorf("duration must be between 0 and %s for scheduled maintenance windows", maxScheduledWindowDuration)
+	}
+	s, err := schedule.Parse(spec.Schedule)
+	if err != nil {
+		return false, err
+	}
+	_, fired := s.LastFired(now.UTC(), spec.Duration.Duration)
+	return fired, nil
+}
+
+// windowSelects reports whether the maintenance window applies to a resource with the given labels
+func windowSelects(window *v12.AppOpticsMaintenanceWindow, resourceLabels map[string]string) bool {
+	selector, err := metav1.LabelSelectorAsSelector(&window.Spec.Selector)
+	if err != nil {
+		return false
+	}
+	return selector.Matches(labels.Set(resourceLabels))
+}
+
+// openMaintenanceWindows returns the names of the maintenance windows muting the alert at now
+func (c *Controller) openMaintenanceWindows(alert *CommonAOResource, now time.Time) ([]string, error) {
+	windows, err := c.windowLister.AppOpticsMaintenanceWindows(alert.Namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, window := range windows {
+		if !windowSelects(window, alert.Labels) {
+			continue
+		}
+		// Invalid windows are reported by checkMaintenanceWindows
+		open, err := maintenanceWindowOpen(window.Spec, now)
+		if err == nil && open {
+			names = append(names, window.Name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// recordMaintenanceWindows stores the names of the maintenance windows open for the synced alert in
+// its status. They are applied as their own field manager after every sync, as an apply that changes
+// nothing leaves the alert as it is. Without server-side apply the sync's update already wrote them,
+// unless it had to be made again over a fresh read of the alert.
+func (c *Controller) recordMaintenanceWindows(aoResource *CommonAOResource, windows []string) error {
+	if c.serverSideApply {
+		if windows == nil {
+			windows = []string{}
+		}
+		return c.applyStatus(Alert, aoResource, MaintenanceFieldManager, map[string][]string{"maintenanceWindows": windows})
+	}
+	if len(aoResource.Status.MaintenanceWindows) == 0 && len(windows) == 0 || reflect.DeepEqual(aoResource.Status.MaintenanceWindows, windows) {
+		return nil
+	}
+	return c.modifyResource(Alert, aoResource, func(fresh *CommonAOResource) {
+		fresh.Status.MaintenanceWindows = windows
+	})
+}
+
+// checkMaintenanceWindows records the maintenance windows that opened or closed since the last check
+// and syncs the alerts they select
+func (c *Controller) checkMaintenanceWindows() {
+	windows, err := c.windowLister.List(labels.Everything())
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	now := time.Now()
+	for _, window := range windows {
+		open, specErr := maintenanceWindowOpen(window.Spec, now)
+		// An invalid spec is reported once, not on every check, until it changes or is fixed
+		status := window.Status
+		valid := status.GetCondition(v12.ConditionSpecValid)
+		wasInvalid := valid != nil && valid.Status == v12.ConditionFalse
+		if specErr != nil && wasInvalid && valid.Message == specErr.Error() {
+			continue
+		}
+		if specErr == nil && !wasInvalid && open == window.Status.Open {
+			continue
+		}
+
+		updated, err := c.updateMaintenanceWindow(window, func(status *v12.MaintenanceWindowStatus) {
+			if specErr != nil {
+				status.SetCondition(v12.ConditionSpecValid, v12.ConditionFalse, ErrInvalidSpec, specErr.Error())
+				return
+			}
+			status.RemoveCondition(v12.ConditionSpecValid)
+			if status.Open != open {
+				status.Open = open
+				status.LastTransitionTime = metav1.NewTime(now)
+			}
+		})
+		if err != nil {
+			runtime.HandleError(fmt.Errorf("error updating maintenance window %s/%s: %s", window.Namespace, window.Name, err.Error()))
+			continue
+		}
+		if specErr != nil {
+			c.recorder.Event(updated, v1.EventTypeWarning, ErrInvalidSpec, specErr.Error())
+			continue
+		}
+		if open == window.Status.Open {
+			continue
+		}
+		if open {
+			c.recorder.Event(updated, v1.EventTypeNormal, MaintenanceWindowOpened, fmt.Sprintf(MessageMaintenanceWindow, "opened"))
+		} else {
+			c.recorder.Event(updated, v1.EventTypeNormal, MaintenanceWindowClosed, fmt.Sprintf(MessageMaintenanceWindow, "closed"))
+		}
+		c.enqueueSelectedAlerts(window)
+	}
+}
+
+// updateMaintenanceWindow applies the change to the status of the window and writes it, retrying
+// against a fresh read of the window for as long as the write conflicts
+func (c *Controller) updateMaintenanceWindow(window *v12.AppOpticsMaintenanceWindow, change func(*v12.MaintenanceWindowStatus)) (*v12.AppOpticsMaintenanceWindow, error) {
+	client := c.aoclientset.AppopticsV1().AppOpticsMaintenanceWindows(window.Namespace)
+	updated := window.DeepCopy()
+	first := true
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if !first {
+			fresh, err := client.Get(window.Name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			updated = fresh
+		}
+		first = false
+		change(&updated.Status)
+		written, err := client.Update(updated)
+		if err != nil {
+			return err
+		}
+		updated = written
+		return nil
+	})
+	return updated, err
+}
+
+// enqueueSelectedAlerts force-syncs every alert the maintenance window selects
+func (c *Controller) enqueueSelectedAlerts(window *v12.AppOpticsMaintenanceWindow) {
+	alerts, err := c.alertLister.AppOpticsAlerts(window.Namespace).List(labels.Everything())
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	for _, alert := range alerts {
+		if windowSelects(window, alert.Labels) {
+			c.enqueueForced(alert, Alert)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package controller
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+	informers "github.com/solarwinds/appoptics-kubernetes-controller/pkg/client/informers/externalversions"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// NamespaceScope picks the namespaces the controller watches: those listed in Names and those whose
+// labels match Selector. With neither set the controller watches the whole cluster.
+type NamespaceScope struct {
+	Names    []string
+	Selector labels.Selector
+}
+
+// ClusterWide reports whether the scope covers every namespace
+func (s NamespaceScope) ClusterWide() bool {
+	return len(s.Names) == 0 && (s.Selector == nil || s.Selector.Empty())
+}
+
+// Includes reports whether the namespace is in the scope
+func (s NamespaceScope) Includes(namespace *corev1.Namespace) bool {
+	if s.ClusterWide() {
+		return true
+	}
+	for _, name := range s.Names {
+		if namespace.Name == name {
+			return true
+		}
+	}
+	return s.selects() && s.Selector.Matches(labels.Set(namespace.Labels))
+}
+
+// selects reports whether the scope picks namespaces by their labels
+func (s NamespaceScope) selects() bool {
+	return s.Selector != nil && !s.Selector.Empty()
+}
+
+// namespacedInformer mirrors the informers of one kind in every watched namespace into a single
+// indexer, which the controller's listers read, and passes their events on to its handlers
+type namespacedInformer struct {
+	indexer  cache.Indexer
+	informer func(ao informers.SharedInformerFactory, kube kubeinformers.SharedInformerFactory, namespace string) cache.SharedIndexInformer
+	handlers []cache.ResourceEventHandler
+}
+
+func newNamespacedInformer(informer func(ao informers.SharedInformerFactory, kube kubeinformers.SharedInformerFactory, namespace string) cache.SharedIndexInformer, indexers cache.Indexers) *namespacedInformer {
+	allIndexers := cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}
+	for name, indexFunc := range indexers {
+		allIndexers[name] = indexFunc
+	}
+	return &namespacedInformer{
+		indexer:  cache.NewIndexer(cache.DeletionHandlingMetaNamespaceKeyFunc, allIndexers),
+		informer: informer,
+	}
+}
+
+// AddEventHandler adds a handler of the events of every watched namespace
+func (n *namespacedInformer) AddEventHandler(handler cache.ResourceEventHandler) {
+	n.handlers = append(n.handlers, handler)
+}
+
+// GetIndexer returns the indexer holding the objects of every watched namespace
+func (n *namespacedInformer) GetIndexer() cache.Indexer {
+	return n.indexer
+}
+
+// watch starts mirroring the informer of the factories' namespace until stopCh is closed. The
+// indexer is updated before the handlers run, so they find the object in the controller's listers.
+func (n *namespacedInformer) watch(ao informers.SharedInformerFactory, kube kubeinformers.SharedInformerFactory, namespace string, stopCh <-chan struct{}) cache.InformerSynced {
+	stopped := func() bool {
+		select {
+		case <-stopCh:
+			return true
+		default:
+			return false
+		}
+	}
+	informer := n.informer(ao, kube, namespace)
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if stopped() {
+				return
+			}
+			if err := n.indexer.Add(obj); err != nil {
+				runtime.HandleError(err)
+				return
+			}
+			for _, handler := range n.handlers {
+				handler.OnAdd(obj)
+			}
+		},
+		UpdateFunc: func(old, new interface{}) {
+			if stopped() {
+				return
+			}
+			if err := n.indexer.Update(new); err != nil {
+				runtime.HandleError(err)
+				return
+			}
+			for _, handler := range n.handlers {
+				handler.OnUpdate(old, new)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if stopped() {
+				return
+			}
+			if err := n.indexer.Delete(obj); err != nil {
+				runtime.HandleError(err)
+				return
+			}
+			for _, handler := range n.handlers {
+				handler.OnDelete(obj)
+			}
+		},
+	})
+	return informer.HasSynced
+}
+
+// forget drops the objects of a namespace that is no longer watched
+func (n *namespacedInformer) forget(namespace string) {
+	objs, err := n.indexer.ByIndex(cache.NamespaceIndex, namespace)
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	for _, obj := range objs {
+		if err := n.indexer.Delete(obj); err != nil {
+			runtime.HandleError(err)
+		}
+	}
+}
+
+// namespaceWatch is the set of informers of one watched namespace
+type namespaceWatch struct {
+	stopCh chan struct{}
+	synced []cache.InformerSynced
+}
+
+// watchNamespace starts a set of informers for the namespace, metav1.NamespaceAll for the whole cluster
+func (c *Controller) watchNamespace(namespace string) {
+	c.watchedLock.Lock()
+	defer c.watchedLock.Unlock()
+	if _, ok := c.watched[namespace]; ok {
+		return
+	}
+
+	aoFactory := informers.NewFilteredSharedInformerFactory(c.aoclientset, c.informerResync, namespace, nil)
+	kubeFactory := kubeinformers.NewFilteredSharedInformerFactory(c.kubeclientset, c.informerResync, namespace, nil)
+	watch := &namespaceWatch{stopCh: make(chan struct{})}
+	for _, informer := range c.informers {
+		watch.synced = append(watch.synced, informer.watch(aoFactory, kubeFactory, namespace, watch.stopCh))
+	}
+	aoFactory.Start(watch.stopCh)
+	kubeFactory.Start(watch.stopCh)
+	c.watched[namespace] = watch
+	glog.Infof("Watching namespace %q", namespace)
+}
+
+// unwatchNamespace stops the informers of the namespace and forgets its resources. They are left
+// as they are in AppOptics.
+func (c *Controller) unwatchNamespace(namespace string) {
+	c.watchedLock.Lock()
+	defer c.watchedLock.Unlock()
+	watch, ok := c.watched[namespace]
+	if !ok {
+		return
+	}
+
+	close(watch.stopCh)
+	delete(c.watched, namespace)
+	for _, informer := range c.informers {
+		informer.forget(namespace)
+	}
+	glog.Infof("Stopped watching namespace %q", namespace)
+}
+
+// watchedSynced returns the InformerSynced of every watched namespace
+func (c *Controller) watchedSynced() []cache.InformerSynced {
+	c.watchedLock.Lock()
+	defer c.watchedLock.Unlock()
+	var synced []cache.InformerSynced
+	for _, watch := range c.watched {
+		synced = append(synced, watch.synced...)
+	}
+	return synced
+}
+
+// scopeNamespace watches or stops watching a namespace when it appears or its labels change
+func (c *Controller) scopeNamespace(obj interface{}) {
+	namespace, ok := obj.(*corev1.Namespace)
+	if !ok {
+		runtime.HandleError(fmt.Errorf("expected Namespace but got %#v", obj))
+		return
+	}
+	if c.scope.Includes(namespace) {
+		c.watchNamespace(namespace.Name)
+	} else {
+		c.unwatchNamespace(namespace.Name)
+	}
+}
+
+// startWatching watches the namespaces in scope. Listed namespaces are watched straight away, so a
+// scope of names alone needs no permission to list or watch namespaces. With a selector the
+// namespaces that appear or come into scope later are watched too.
+func (c *Controller) startWatching(stopCh <-chan struct{}) error {
+	if c.scope.ClusterWide() {
+		c.watchNamespace(metav1.NamespaceAll)
+		return nil
+	}
+	for _, name := range c.scope.Names {
+		c.watchNamespace(name)
+	}
+	if !c.scope.selects() {
+		return nil
+	}
+
+	namespaceInformer := kubeinformers.NewSharedInformerFactory(c.kubeclientset, c.informerResync).Core().V1().Namespaces().Informer()
+	namespaceInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.scopeNamespace,
+		UpdateFunc: func(old, new interface{}) {
+			c.scopeNamespace(new)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if namespace, ok := obj.(*corev1.Namespace); ok {
+				c.unwatchNamespace(namespace.Name)
+			}
+		},
+	})
+	go namespaceInformer.Run(stopCh)
+	if ok := cache.WaitForCacheSync(stopCh, namespaceInformer.HasSynced); !ok {
+		return fmt.Errorf("failed to wait for the namespace cache to sync")
+	}
+	// The handlers may not have run yet, and the caller waits for the caches of the namespaces
+	// watched by the time this returns
+	for _, obj := range namespaceInformer.GetStore().List() {
+		c.scopeNamespace(obj)
+	}
+	return nil
+}
+
+// stopWatching stops the informers of every watched namespace
+func (c *Controller) stopWatching() {
+	c.watchedLock.Lock()
+	defer c.watchedLock.Unlock()
+	for namespace, watch := range c.watched {
+		close(watch.stopCh)
+		delete(c.watched, namespace)
+	}
+}
@@ -0,0 +1,107 @@
+package controller
+
+import (
+	"testing"
+
+	informers "github.com/solarwinds/appoptics-kubernetes-controller/pkg/client/informers/externalversions"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newTestNamespace(name string, namespaceLabels map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: namespaceLabels}}
+}
+
+// newTestScopeController returns a controller watching ConfigMaps with informers that are never
+// run, so namespaces can be watched without an API server
+func newTestScopeController(scope NamespaceScope) *Controller {
+	informer := newNamespacedInformer(func(ao informers.SharedInformerFactory, kube kubeinformers.SharedInformerFactory, namespace string) cache.SharedIndexInformer {
+		return cache.NewSharedIndexInformer(&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return &corev1.ConfigMapList{}, nil
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return watch.NewFake(), nil
+			},
+		}, &corev1.ConfigMap{}, 0, cache.Indexers{})
+	}, nil)
+	return &Controller{
+		informers: []*namespacedInformer{informer},
+		scope:     scope,
+		watched:   map[string]*namespaceWatch{},
+	}
+}
+
+func watchedNamespaces(c *Controller) []string {
+	var namespaces []string
+	for namespace := range c.watched {
+		namespaces = append(namespaces, namespace)
+	}
+	return namespaces
+}
+
+func TestNamespaceScopeClusterWide(t *testing.T) {
+	assert.True(t, NamespaceScope{}.ClusterWide())
+	assert.True(t, NamespaceScope{Selector: labels.Everything()}.ClusterWide())
+	assert.False(t, NamespaceScope{Names: []string{"team-a"}}.ClusterWide())
+	assert.False(t, NamespaceScope{Selector: labels.SelectorFromSet(labels.Set{"appoptics": "enabled"})}.ClusterWide())
+}
+
+func TestNamespaceScopeIncludes(t *testing.T) {
+	scope := NamespaceScope{
+		Names:    []string{"team-a"},
+		Selector: labels.SelectorFromSet(labels.Set{"appoptics": "enabled"}),
+	}
+	assert.True(t, scope.Includes(newTestNamespace("team-a", nil)))
+	assert.True(t, scope.Includes(newTestNamespace("team-b", map[string]string{"appoptics": "enabled"})))
+	assert.False(t, scope.Includes(newTestNamespace("team-c", map[string]string{"appoptics": "disabled"})))
+
+	assert.False(t, NamespaceScope{Names: []string{"team-a"}}.Includes(newTestNamespace("team-b", nil)))
+	assert.True(t, NamespaceScope{}.Includes(newTestNamespace("team-b", nil)))
+}
+
+// Tests that a scope of names alone is watched without a namespace informer
+func TestStartWatchingNamesOnly(t *testing.T) {
+	c := newTestScopeController(NamespaceScope{Names: []string{"team-a", "team-b"}})
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	err := c.startWatching(stopCh)
+	assert.Nil(t, err)
+	defer c.stopWatching()
+	assert.ElementsMatch(t, []string{"team-a", "team-b"}, watchedNamespaces(c))
+	assert.Equal(t, 2, len(c.watchedSynced()))
+}
+
+func TestScopeNamespaceFollowsLabels(t *testing.T) {
+	c := newTestScopeController(NamespaceScope{Selector: labels.SelectorFromSet(labels.Set{"appoptics": "enabled"})})
+	defer c.stopWatching()
+
+	c.scopeNamespace(newTestNamespace("team-a", map[string]string{"appoptics": "enabled"}))
+	c.scopeNamespace(newTestNamespace("team-b", nil))
+	assert.Equal(t, []string{"team-a"}, watchedNamespaces(c))
+
+	c.scopeNamespace(newTestNamespace("team-a", nil))
+	assert.Equal(t, 0, len(watchedNamespaces(c)))
+}
+
+// Tests that the resources of a namespace that is no longer watched are dropped from the listers
+func TestUnwatchNamespaceForgetsResources(t *testing.T) {
+	c := newTestScopeController(NamespaceScope{Names: []string{"team-a", "team-b"}})
+	indexer := c.informers[0].GetIndexer()
+	indexer.Add(&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "one"}})
+	indexer.Add(&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "team-b", Name: "two"}})
+	c.watchNamespace("team-a")
+	c.watchNamespace("team-b")
+	defer c.stopWatching()
+
+	c.unwatchNamespace("team-a")
+	assert.Equal(t, []string{"team-b"}, watchedNamespaces(c))
+	assert.Equal(t, []string{"team-b/two"}, indexer.ListKeys())
+}
@@ -0,0 +1,123 @@
+package controller
+
+import (
+	"time"
+
+	v12 "github.com/solarwinds/appoptics-kubernetes-controller/pkg/apis/appoptics-kubernetes-controller/v1"
+	"github.com/solarwinds/appoptics-kubernetes-controller/pkg/controller/appoptics"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// serviceRefIndex indexes alerts by the namespace/name of every AppOpticsService they reference
+const serviceRefIndex = "serviceRef"
+
+// alertServiceRefIndexFunc is the index function of serviceRefIndex. Alerts with invalid data are
+// not indexed, they will be resynced once their spec is fixed anyway.
+func alertServiceRefIndexFunc(obj interface{}) ([]string, error) {
+	alert, ok := obj.(*v12.AppOpticsAlert)
+	if !ok {
+		return nil, nil
+	}
+	customAlert, err := appoptics.ParseAlert(alert.Spec.Data)
+	if err != nil {
+		return nil, nil
+	}
+	refs, err := appoptics.AlertServiceRefs(alert.Namespace, alert.Spec, customAlert)
+	if err != nil {
+		return nil, nil
+	}
+	keys := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		keys = append(keys, ref.Namespace+"/"+ref.Name)
+	}
+	return keys, nil
+}
+
+// enqueueReferencingAlerts force-syncs every alert that references the AppOpticsService namespace/name
+func (c *Controller) enqueueReferencingAlerts(namespace, name string) {
+	alerts, err := c.alertIndexer.ByIndex(serviceRefIndex, namespace+"/"+name)
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	for _, alert := range alerts {
+		c.enqueueForced(alert, Alert)
+	}
+}
+
+// serviceSecretIndex indexes services by the namespace/name of every Secret their settings read
+const serviceSecretIndex = "secretRef"
+
+// serviceSecretIndexFunc is the index function of serviceSecretIndex
+func serviceSecretIndexFunc(obj interface{}) ([]string, error) {
+	service, ok := obj.(*v12.AppOpticsService)
+	if !ok {
+		return nil, nil
+	}
+	refs, err := appoptics.SecretKeyRefs(service.Spec.Data)
+	if err != nil {
+		return nil, nil
+	}
+	seen := map[string]bool{}
+	var keys []string
+	for _, ref := range refs {
+		key := service.Namespace + "/" + ref.Name
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// enqueueServicesUsingSecret force-syncs every service whose settings read the Secret namespace/name
+func (c *Controller) enqueueServicesUsingSecret(namespace, name string) {
+	services, err := c.serviceIndexer.ByIndex(serviceSecretIndex, namespace+"/"+name)
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	for _, service := range services {
+		c.enqueueForced(service, Service)
+	}
+}
+
+// newSecretMetadataInformer returns an informer of the Secrets of the namespace that drops their data
+// before caching them. The values are only read, with a get, when a service using them is synced.
+func newSecretMetadataInformer(client kubernetes.Interface, namespace string, resync time.Duration) cache.SharedIndexInformer {
+	return cache.NewSharedIndexInformer(&cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (k8sruntime.Object, error) {
+			list, err := client.CoreV1().Secrets(namespace).List(options)
+			if err != nil {
+				return nil, err
+			}
+			for i := range list.Items {
+				dropSecretData(&list.Items[i])
+			}
+			return list, nil
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			w, err := client.CoreV1().Secrets(namespace).Watch(options)
+			if err != nil {
+				return nil, err
+			}
+			return watch.Filter(w, func(event watch.Event) (watch.Event, bool) {
+				if secret, ok := event.Object.(*corev1.Secret); ok {
+					dropSecretData(secret)
+				}
+				return event, true
+			}), nil
+		},
+	}, &corev1.Secret{}, resync, cache.Indexers{})
+}
+
+func dropSecretData(secret *corev1.Secret) {
+	secret.Data = nil
+	secret.StringData = nil
+}
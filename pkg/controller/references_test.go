@@ -0,0 +1,127 @@
+package controller
+
+import (
+	"testing"
+
+	v12 "github.com/solarwinds/appoptics-kubernetes-controller/pkg/apis/appoptics-kubernetes-controller/v1"
+	"github.com/solarwinds/appoptics-kubernetes-controller/pkg/config"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newTestService(namespace string, name string, ID int) *v12.AppOpticsService {
+	return &v12.AppOpticsService{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Status:     v12.Status{ID: ID},
+	}
+}
+
+// newTestReferencesController returns a controller caching an alert in namespace web that notifies
+// the service ops of namespace monitoring, and one in monitoring that notifies nothing
+func newTestReferencesController() *Controller {
+	referencing := &v12.AppOpticsAlert{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "errors"},
+		Spec: v12.TokenAndDataSpec{
+			Data:        "name: web.errors\n",
+			ServiceRefs: []v12.ObjectReference{{Namespace: "monitoring", Name: "ops"}},
+		},
+	}
+	unrelated := &v12.AppOpticsAlert{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "monitoring", Name: "latency"},
+		Spec:       v12.TokenAndDataSpec{Data: "name: monitoring.latency\n"},
+	}
+	return newTestController(config.Default(), referencing, unrelated, newTestService("monitoring", "ops", 1))
+}
+
+// serviceHandlers returns the handlers NewController adds to the services' informer
+func serviceHandlers(c *Controller) []cache.ResourceEventHandler {
+	return c.informers[testInformerIndex(&v12.AppOpticsService{})].handlers
+}
+
+func TestServiceIDChangeEnqueuesReferencingAlerts(t *testing.T) {
+	c := newTestReferencesController()
+	for _, handler := range serviceHandlers(c) {
+		handler.OnUpdate(newTestService("monitoring", "ops", 1), newTestService("monitoring", "ops", 2))
+	}
+
+	assert.ElementsMatch(t, []string{"monitoring/Service/ops", "web/Alert/errors"}, queuedKeys(c))
+	assert.Equal(t, 1, c.forcedRequests("web/Alert/errors"))
+	assert.Equal(t, 0, c.forcedRequests("monitoring/Alert/latency"))
+}
+
+func TestServiceStatusUpdateKeepingIDSkipsAlerts(t *testing.T) {
+	c := newTestReferencesController()
+	updated := newTestService("monitoring", "ops", 1)
+	updated.Status.LastUpdated = "Mon, 02 Jan 2006 15:04:05 -0700"
+	for _, handler := range serviceHandlers(c) {
+		handler.OnUpdate(newTestService("monitoring", "ops", 1), updated)
+	}
+
+	assert.Equal(t, []string{"monitoring/Service/ops"}, queuedKeys(c))
+	assert.Equal(t, 0, c.forcedRequests("web/Alert/errors"))
+}
+
+func TestServiceDeletionEnqueuesReferencingAlerts(t *testing.T) {
+	c := newTestReferencesController()
+	for _, handler := range serviceHandlers(c) {
+		handler.OnDelete(cache.DeletedFinalStateUnknown{Key: "monitoring/ops", Obj: newTestService("monitoring", "ops", 1)})
+	}
+
+	assert.Equal(t, []string{"web/Alert/errors"}, queuedKeys(c))
+	assert.Equal(t, 1, c.forcedRequests("web/Alert/errors"))
+}
+
+// secretHandlers returns the handlers NewController adds to the Secrets' informer
+func secretHandlers(c *Controller) []cache.ResourceEventHandler {
+	return c.informers[testInformerIndex(&corev1.Secret{})].handlers
+}
+
+func newTestSecret(resourceVersion string) *corev1.Secret {
+	return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "monitoring", Name: "slack", ResourceVersion: resourceVersion}}
+}
+
+// newTestSecretsController returns a controller caching a service that reads its URL from the Secret
+// slack, and one that reads nothing
+func newTestSecretsController() *Controller {
+	reading := newTestService("monitoring", "ops", 1)
+	reading.Spec.Data = `
+type: slack
+title: Ops channel
+settings:
+  url:
+    valueFrom:
+      secretKeyRef:
+        name: slack
+        key: webhook-url
+`
+	plain := newTestService("monitoring", "mail", 2)
+	plain.Spec.Data = "type: mail\ntitle: Ops mail\nsettings:\n  addresses: ops@example.com\n"
+	return newTestController(config.Default(), reading, plain)
+}
+
+func TestSecretChangeEnqueuesServicesUsingIt(t *testing.T) {
+	c := newTestSecretsController()
+	for _, handler := range secretHandlers(c) {
+		handler.OnAdd(newTestSecret("1"))
+	}
+	assert.Equal(t, []string{"monitoring/Service/ops"}, queuedKeys(c))
+	assert.Equal(t, 1, c.forcedRequests("monitoring/Service/ops"))
+
+	for _, handler := range secretHandlers(c) {
+		handler.OnUpdate(newTestSecret("1"), newTestSecret("2"))
+	}
+	assert.Equal(t, []string{"monitoring/Service/ops"}, queuedKeys(c))
+	assert.Equal(t, 2, c.forcedRequests("monitoring/Service/ops"))
+	assert.Equal(t, 0, c.forcedRequests("monitoring/Service/mail"))
+}
+
+// Tests that the periodic resync of the Secrets' informer does not sync the services again
+func TestSecretResyncSkipsServices(t *testing.T) {
+	c := newTestSecretsController()
+	for _, handler := range secretHandlers(c) {
+		handler.OnUpdate(newTestSecret("1"), newTestSecret("1"))
+	}
+	assert.Equal(t, 0, len(queuedKeys(c)))
+}
@@ -0,0 +1,343 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	v12 "github.com/solarwinds/appoptics-kubernetes-controller/pkg/apis/appoptics-kubernetes-controller/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// FieldManager is the field manager the controller applies the status and finalizers of resources as
+	FieldManager = "appoptics-controller"
+
+	// PollerFieldManager is the field manager the alert state polled from AppOptics is applied as
+	PollerFieldManager = "appoptics-controller-poller"
+
+	// MaintenanceFieldManager is the field manager the open maintenance windows of alerts are applied as
+	MaintenanceFieldManager = "appoptics-controller-maintenance"
+
+	// applyPatchType is the content type of a server-side apply patch, which this client-go predates
+	applyPatchType = types.PatchType("application/apply-patch+yaml")
+)
+
+// resourcePlurals maps the kinds the controller syncs to the names of their resources
+var resourcePlurals = map[string]string{
+	Dashboard:       "appopticsdashboards",
+	Service:         "appopticsservices",
+	Alert:           "appopticsalerts",
+	Metric:          "appopticsmetrics",
+	CompositeMetric: "appopticscompositemetrics",
+}
+
+// getResource returns a copy of the cached resource of the given kind, safe to modify
+func (c *Controller) getResource(kind, namespace, name string) (*CommonAOResource, error) {
+	var aoResource CommonAOResource
+	switch kind {
+	case Dashboard:
+		dashboard, err := c.dashboardLister.AppOpticsDashboards(namespace).Get(name)
+		if err != nil {
+			return nil, err
+		}
+		aoResource = CommonAOResource(*dashboard.DeepCopy())
+	case Service:
+		service, err := c.serviceLister.AppOpticsServices(namespace).Get(name)
+		if err != nil {
+			return nil, err
+		}
+		aoResource = CommonAOResource(*service.DeepCopy())
+	case Alert:
+		alert, err := c.alertLister.AppOpticsAlerts(namespace).Get(name)
+		if err != nil {
+			return nil, err
+		}
+		aoResource = CommonAOResource(*alert.DeepCopy())
+	case Metric:
+		metric, err := c.metricLister.AppOpticsMetrics(namespace).Get(name)
+		if err != nil {
+			return nil, err
+		}
+		aoResource = CommonAOResource(*metric.DeepCopy())
+	case CompositeMetric:
+		compositeMetric, err := c.compositeLister.AppOpticsCompositeMetrics(namespace).Get(name)
+		if err != nil {
+			return nil, err
+		}
+		aoResource = CommonAOResource(*compositeMetric.DeepCopy())
+	default:
+		return nil, fmt.Errorf("unknown kind %s", kind)
+	}
+	return &aoResource, nil
+}
+
+// updateResource writes the status and finalizer of the resource back to the API server. They are
+// applied server-side as the controller's field manager, which leaves the spec, and any field a GitOps
+// tool applies, to their own managers. API servers without server-side apply get an update instead,
+// made against a fresh read of the resource on a conflict. A fresh read only gets the finalizer added
+// or removed and the fields of the status the sync owns, so what others wrote meanwhile is kept.
+func (c *Controller) updateResource(kind string, aoResource *CommonAOResource) error {
+	status, finalizer := aoResource.Status, addFinalizer(hasFinalizer(aoResource))
+	if !c.serverSideApply {
+		return c.modifyResource(kind, aoResource, func(fresh *CommonAOResource) {
+			c.finalizers(fresh, finalizer)
+			fresh.Status = syncedStatus(fresh.Status, status)
+		})
+	}
+
+	applied, err := c.applyResource(kind, aoResource)
+	if err != nil {
+		return err
+	}
+	aoResource.ResourceVersion = applied.ResourceVersion
+	if finalizer == remove && hasFinalizer(applied) {
+		// An apply only removes what it applied, not a finalizer an update added before the
+		// controller applied it, which would keep the resource from ever being deleted
+		return c.modifyResource(kind, aoResource, func(fresh *CommonAOResource) {
+			c.finalizers(fresh, remove)
+		})
+	}
+	return nil
+}
+
+// syncedStatus returns the status a sync wrote, with the state of the alert and its open maintenance
+// windows kept from the current status. They are written by recordAlertState and
+// recordMaintenanceWindows, which may have changed them since the sync read the resource.
+func syncedStatus(current, synced v12.Status) v12.Status {
+	synced.Firing = current.Firing
+	synced.Active = current.Active
+	synced.FiringObservedAt = current.FiringObservedAt
+	synced.MaintenanceWindows = current.MaintenanceWindows
+	return synced
+}
+
+// hasFinalizer reports whether the resource has the controller's finalizer
+func hasFinalizer(aoResource *CommonAOResource) bool {
+	for _, finalizer := range aoResource.Finalizers {
+		if finalizer == AppopticsFinalizer {
+			return true
+		}
+	}
+	return false
+}
+
+// detectServerSideApply picks how resources are written for the rest of the run, by whether the API
+// server has server-side apply, on by default since Kubernetes 1.16
+func (c *Controller) detectServerSideApply() {
+	info, err := c.kubeclientset.Discovery().ServerVersion()
+	if err != nil {
+		glog.Warningf("Failed to read the API server version, writing resources with updates: %v", err)
+		return
+	}
+	major, _ := strconv.Atoi(strings.TrimSuffix(info.Major, "+"))
+	minor, _ := strconv.Atoi(strings.TrimSuffix(info.Minor, "+"))
+	c.serverSideApply = major > 1 || (major == 1 && minor >= 16)
+	if c.serverSideApply {
+		glog.Infof("Applying resources server-side as %s on Kubernetes %s.%s", FieldManager, info.Major, info.Minor)
+	} else {
+		glog.Infof("Writing resources with updates on Kubernetes %s.%s, which lacks server-side apply", info.Major, info.Minor)
+	}
+}
+
+// applyResource applies the controller's finalizer, or its removal, and the fields of the status the
+// sync owns, taking them over from any other field manager. It returns the resource as stored.
+// Finalizers of anything else, the alert state and the open maintenance windows are left to their
+// managers.
+func (c *Controller) applyResource(kind string, aoResource *CommonAOResource) (*CommonAOResource, error) {
+	body, err := applyBody(kind, aoResource)
+	if err != nil {
+		return nil, err
+	}
+	return c.patchResource(kind, aoResource, FieldManager, body)
+}
+
+// applyBody returns the apply patch of the controller's finalizer, if the resource has it, and of the
+// status without the fields recordAlertState and recordMaintenanceWindows apply
+func applyBody(kind string, aoResource *CommonAOResource) ([]byte, error) {
+	finalizers := []string{}
+	if hasFinalizer(aoResource) {
+		finalizers = append(finalizers, AppopticsFinalizer)
+	}
+	return json.Marshal(map[string]interface{}{
+		"apiVersion": v12.SchemeGroupVersion.String(),
+		"kind":       "AppOptics" + kind,
+		"metadata": map[string]interface{}{
+			"name":       aoResource.Name,
+			"namespace":  aoResource.Namespace,
+			"finalizers": finalizers,
+		},
+		"status": syncedStatus(v12.Status{}, aoResource.Status),
+	})
+}
+
+// applyStatus applies only the fields of the status as the field manager, which must send every field
+// it owns each time, as an apply removes the fields its manager left out. The resource is left holding
+// the resourceVersion it was stored with.
+func (c *Controller) applyStatus(kind string, aoResource *CommonAOResource, fieldManager string, status interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"apiVersion": v12.SchemeGroupVersion.String(),
+		"kind":       "AppOptics" + kind,
+		"metadata": map[string]interface{}{
+			"name":      aoResource.Name,
+			"namespace": aoResource.Namespace,
+		},
+		"status": status,
+	})
+	if err != nil {
+		return err
+	}
+	applied, err := c.patchResource(kind, aoResource, fieldManager, body)
+	if err != nil {
+		return err
+	}
+	aoResource.ResourceVersion = applied.ResourceVersion
+	return nil
+}
+
+// patchResource sends the apply patch of the resource as the field manager and returns the resource
+// as stored
+func (c *Controller) patchResource(kind string, aoResource *CommonAOResource, fieldManager string, body []byte) (*CommonAOResource, error) {
+	resource, ok := resourcePlurals[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown kind %s", kind)
+	}
+	raw, err := c.aoclientset.AppopticsV1().RESTClient().Patch(applyPatchType).
+		Namespace(aoResource.Namespace).
+		Resource(resource).
+		Name(aoResource.Name).
+		Param("fieldManager", fieldManager).
+		Param("force", "true").
+		Body(body).
+		Do().
+		Raw()
+	if err != nil {
+		return nil, err
+	}
+	var applied CommonAOResource
+	if err := json.Unmarshal(raw, &applied); err != nil {
+		return nil, err
+	}
+	return &applied, nil
+}
+
+// modifyResource applies the change to the resource and writes it, retrying against a fresh read of
+// the resource with the change applied again for as long as the write conflicts. The resource is left
+// holding what was written.
+func (c *Controller) modifyResource(kind string, aoResource *CommonAOResource, change func(*CommonAOResource)) error {
+	first := true
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if !first {
+			fresh, err := c.readResource(kind, aoResource.Namespace, aoResource.Name)
+			if err != nil {
+				return err
+			}
+			*aoResource = *fresh
+		}
+		first = false
+		change(aoResource)
+		resourceVersion, err := c.writeResource(kind, aoResource)
+		if err != nil {
+			return err
+		}
+		aoResource.ResourceVersion = resourceVersion
+		return nil
+	})
+}
+
+// readResource gets the resource from the API server rather than the informer's cache, which may not
+// have seen the latest write yet
+func (c *Controller) readResource(kind, namespace, name string) (*CommonAOResource, error) {
+	var aoResource CommonAOResource
+	switch kind {
+	case Dashboard:
+		dashboard, err := c.aoclientset.AppopticsV1().AppOpticsDashboards(namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		aoResource = CommonAOResource(*dashboard)
+	case Service:
+		service, err := c.aoclientset.AppopticsV1().AppOpticsServices(namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		aoResource = CommonAOResource(*service)
+	case Alert:
+		alert, err := c.aoclientset.AppopticsV1().AppOpticsAlerts(namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		aoResource = CommonAOResource(*alert)
+	case Metric:
+		metric, err := c.aoclientset.AppopticsV1().AppOpticsMetrics(namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		aoResource = CommonAOResource(*metric)
+	case CompositeMetric:
+		compositeMetric, err := c.aoclientset.AppopticsV1().AppOpticsCompositeMetrics(namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		aoResource = CommonAOResource(*compositeMetric)
+	default:
+		return nil, fmt.Errorf("unknown kind %s", kind)
+	}
+	return &aoResource, nil
+}
+
+// writeResource updates the resource as it is and returns its new resourceVersion
+func (c *Controller) writeResource(kind string, aoResource *CommonAOResource) (string, error) {
+	var written metav1.Object
+	var err error
+	switch kind {
+	case Dashboard:
+		dashboard := v12.AppOpticsDashboard(*aoResource)
+		written, err = c.aoclientset.AppopticsV1().AppOpticsDashboards(aoResource.Namespace).Update(&dashboard)
+	case Service:
+		service := v12.AppOpticsService(*aoResource)
+		written, err = c.aoclientset.AppopticsV1().AppOpticsServices(aoResource.Namespace).Update(&service)
+	case Alert:
+		alert := v12.AppOpticsAlert(*aoResource)
+		written, err = c.aoclientset.AppopticsV1().AppOpticsAlerts(aoResource.Namespace).Update(&alert)
+	case Metric:
+		metric := v12.AppOpticsMetric(*aoResource)
+		written, err = c.aoclientset.AppopticsV1().AppOpticsMetrics(aoResource.Namespace).Update(&metric)
+	case CompositeMetric:
+		compositeMetric := v12.AppOpticsCompositeMetric(*aoResource)
+		written, err = c.aoclientset.AppopticsV1().AppOpticsCompositeMetrics(aoResource.Namespace).Update(&compositeMetric)
+	default:
+		return "", fmt.Errorf("unknown kind %s", kind)
+	}
+	if err != nil {
+		return "", err
+	}
+	return written.GetResourceVersion(), nil
+}
+
+// toObject converts the resource back to its registered type so it can be used for Events
+func toObject(kind string, aoResource *CommonAOResource) k8sruntime.Object {
+	switch kind {
+	case Dashboard:
+		dashboard := v12.AppOpticsDashboard(*aoResource)
+		return &dashboard
+	case Service:
+		service := v12.AppOpticsService(*aoResource)
+		return &service
+	case Alert:
+		alert := v12.AppOpticsAlert(*aoResource)
+		return &alert
+	case Metric:
+		metric := v12.AppOpticsMetric(*aoResource)
+		return &metric
+	case CompositeMetric:
+		compositeMetric := v12.AppOpticsCompositeMetric(*aoResource)
+		return &compositeMetric
+	}
+	return nil
+}
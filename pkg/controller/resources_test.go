@@ -0,0 +1,211 @@
+package controller
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	v12 "github.com/solarwinds/appoptics-kubernetes-controller/pkg/apis/appoptics-kubernetes-controller/v1"
+	clientset "github.com/solarwinds/appoptics-kubernetes-controller/pkg/client/clientset/versioned"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestFinalizersKeepOthers(t *testing.T) {
+	c := &Controller{}
+	aoResource := &CommonAOResource{ObjectMeta: metav1.ObjectMeta{Finalizers: []string{"example.com/backup"}}}
+
+	c.finalizers(aoResource, add)
+	assert.Equal(t, []string{"example.com/backup", AppopticsFinalizer}, aoResource.Finalizers)
+	c.finalizers(aoResource, add)
+	assert.Equal(t, []string{"example.com/backup", AppopticsFinalizer}, aoResource.Finalizers)
+
+	c.finalizers(aoResource, remove)
+	assert.Equal(t, []string{"example.com/backup"}, aoResource.Finalizers)
+}
+
+// Tests that writing a synced status over a fresh read keeps the alert state polled meanwhile
+func TestSyncedStatusKeepsAlertState(t *testing.T) {
+	firing := true
+	observedAt := metav1.Now()
+	current := v12.Status{ID: 1, Firing: &firing, Active: &firing, FiringObservedAt: &observedAt, MaintenanceWindows: []string{"deploy"}}
+	synced := v12.Status{ID: 2, LastUpdated: "now"}
+
+	status := syncedStatus(current, synced)
+	assert.Equal(t, 2, status.ID)
+	assert.Equal(t, "now", status.LastUpdated)
+	assert.Equal(t, &firing, status.Firing)
+	assert.Equal(t, &firing, status.Active)
+	assert.Equal(t, &observedAt, status.FiringObservedAt)
+	assert.Equal(t, []string{"deploy"}, status.MaintenanceWindows)
+}
+
+// testApplyServer answers apply patches with the patched object stored at resourceVersion 2, recording
+// each request and its body
+type testApplyServer struct {
+	*httptest.Server
+	sync.Mutex
+	requests []*http.Request
+	bodies   []map[string]interface{}
+}
+
+func (server *testApplyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	server.Lock()
+	defer server.Unlock()
+	raw, _ := ioutil.ReadAll(r.Body)
+	var body map[string]interface{}
+	if err := json.Unmarshal(raw, &body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	server.requests = append(server.requests, r)
+	server.bodies = append(server.bodies, body)
+	body["metadata"].(map[string]interface{})["resourceVersion"] = "2"
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+// newTestApplyServer starts a testApplyServer and returns it with an AppOptics clientset using it. The
+// server must be closed by the test.
+func newTestApplyServer(t *testing.T) (*testApplyServer, clientset.Interface) {
+	server := &testApplyServer{}
+	server.Server = httptest.NewServer(server)
+	client, err := clientset.NewForConfig(&rest.Config{Host: server.URL})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	return server, client
+}
+
+func newTestApplyResource() *CommonAOResource {
+	firing := true
+	observedAt := metav1.Now()
+	return &CommonAOResource{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "errors", Finalizers: []string{"example.com/backup", AppopticsFinalizer}},
+		Status: v12.Status{ID: 1, LastUpdated: "now", Firing: &firing, Active: &firing,
+			FiringObservedAt: &observedAt, MaintenanceWindows: []string{"deploy"}},
+	}
+}
+
+// Tests that the apply patch of a sync only holds the controller's finalizer and the status without
+// the fields applied by other field managers
+func TestApplyBodyOnlyHoldsSyncedFields(t *testing.T) {
+	body, err := applyBody(Alert, newTestApplyResource())
+	assert.Nil(t, err)
+	var applied map[string]interface{}
+	assert.Nil(t, json.Unmarshal(body, &applied))
+
+	assert.Equal(t, "appoptics.io/v1", applied["apiVersion"])
+	assert.Equal(t, "AppOpticsAlert", applied["kind"])
+	assert.Equal(t, map[string]interface{}{
+		"namespace":  "web",
+		"name":       "errors",
+		"finalizers": []interface{}{AppopticsFinalizer},
+	}, applied["metadata"])
+	status := applied["status"].(map[string]interface{})
+	assert.Equal(t, float64(1), status["id"])
+	assert.Equal(t, "now", status["lastUpdated"])
+	for _, polled := range []string{"firing", "active", "firingObservedAt", "maintenanceWindows"} {
+		assert.NotContains(t, status, polled)
+	}
+}
+
+// Tests that a resource without the finalizer applies an empty list, which removes the finalizer the
+// controller applied before
+func TestApplyBodyRemovesFinalizer(t *testing.T) {
+	aoResource := newTestApplyResource()
+	aoResource.Finalizers = []string{"example.com/backup"}
+	body, err := applyBody(Alert, aoResource)
+	assert.Nil(t, err)
+	var applied map[string]interface{}
+	assert.Nil(t, json.Unmarshal(body, &applied))
+	assert.Equal(t, []interface{}{}, applied["metadata"].(map[string]interface{})["finalizers"])
+}
+
+func TestApplyResourcePatchesAsFieldManager(t *testing.T) {
+	server, client := newTestApplyServer(t)
+	defer server.Close()
+	c := &Controller{aoclientset: client}
+
+	applied, err := c.applyResource(Alert, newTestApplyResource())
+	assert.Nil(t, err)
+	assert.Equal(t, "2", applied.ResourceVersion)
+	assert.Equal(t, []string{AppopticsFinalizer}, applied.Finalizers)
+
+	if !assert.Equal(t, 1, len(server.requests)) {
+		return
+	}
+	request := server.requests[0]
+	assert.Equal(t, http.MethodPatch, request.Method)
+	assert.Equal(t, string(applyPatchType), request.Header.Get("Content-Type"))
+	assert.Equal(t, "/apis/appoptics.io/v1/namespaces/web/appopticsalerts/errors", request.URL.Path)
+	assert.Equal(t, FieldManager, request.URL.Query().Get("fieldManager"))
+	assert.Equal(t, "true", request.URL.Query().Get("force"))
+}
+
+// Tests that the alert state is applied as the poller, without the finalizer or the fields of the sync
+func TestRecordAlertStateAppliesAsPoller(t *testing.T) {
+	server, client := newTestApplyServer(t)
+	defer server.Close()
+	notFiring := false
+	c, _ := newTestStateController(newTestStateAlert(&notFiring, nil))
+	c.aoclientset = client
+	c.serverSideApply = true
+	now := time.Now()
+
+	c.recordAlertState(newTestStateAlert(&notFiring, nil), true, true, now)
+	assert.Equal(t, []string{"Warning AlertFiring Alert errors is firing"}, testEvents(c))
+	if !assert.Equal(t, 1, len(server.requests)) {
+		return
+	}
+	assert.Equal(t, PollerFieldManager, server.requests[0].URL.Query().Get("fieldManager"))
+	assert.Equal(t, map[string]interface{}{"namespace": "web", "name": "errors"}, server.bodies[0]["metadata"])
+	status := server.bodies[0]["status"].(map[string]interface{})
+	assert.Equal(t, 3, len(status))
+	assert.Equal(t, true, status["firing"])
+	assert.Equal(t, true, status["active"])
+	assert.Equal(t, now.UTC().Format(time.RFC3339), status["firingObservedAt"])
+}
+
+func TestRecordMaintenanceWindowsAppliesAsItsManager(t *testing.T) {
+	server, client := newTestApplyServer(t)
+	defer server.Close()
+	c := &Controller{aoclientset: client, serverSideApply: true}
+
+	assert.Nil(t, c.recordMaintenanceWindows(newTestApplyResource(), nil))
+	if !assert.Equal(t, 1, len(server.requests)) {
+		return
+	}
+	assert.Equal(t, MaintenanceFieldManager, server.requests[0].URL.Query().Get("fieldManager"))
+	assert.Equal(t, map[string]interface{}{"maintenanceWindows": []interface{}{}}, server.bodies[0]["status"])
+}
+
+func TestDetectServerSideApply(t *testing.T) {
+	for version, serverSideApply := range map[string]bool{"15": false, "16": true, "16+": true, "20": true} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"major": "1", "minor": "` + version + `"}`))
+		}))
+		client, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+		assert.Nil(t, err)
+		c := &Controller{kubeclientset: client}
+		c.detectServerSideApply()
+		assert.Equal(t, serverSideApply, c.serverSideApply, "Kubernetes 1.%s", version)
+		server.Close()
+	}
+
+	// Resources are written with updates when the version cannot be read
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	client, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	assert.Nil(t, err)
+	c := &Controller{kubeclientset: client}
+	c.detectServerSideApply()
+	assert.False(t, c.serverSideApply)
+}
@@ -1,11 +1,14 @@
 package controller
 
 import (
+	"bytes"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/golang/glog"
 	v12 "github.com/solarwinds/appoptics-kubernetes-controller/pkg/apis/appoptics-kubernetes-controller/v1"
+	"github.com/solarwinds/appoptics-kubernetes-controller/pkg/config"
 	"github.com/solarwinds/appoptics-kubernetes-controller/pkg/controller/appoptics"
 	"k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
@@ -24,12 +27,62 @@ const (
 	// SuccessUpdate is used as part of the Event 'reason' when we update the status successfully
 	SuccessUpdate = "SuccessUpdate"
 
+	// ErrInvalidSpec is used as part of the Event 'reason' when a resource fails to sync in a way
+	// that retrying will not fix
+	ErrInvalidSpec = "ErrInvalidSpec"
+
 	// MessageResourceUpdated is the message used for Events when a resource is updated
 	MessageResourceUpdated = "Updated resource %s"
 
+	// DryRunPlanned is used as part of the Event 'reason' for each change a dry run would have made
+	DryRunPlanned = "DryRunPlanned"
+
+	// MessageDryRunPlanned is the message used for the Synced condition of a dry run
+	MessageDryRunPlanned = "Dry run, %d changes not applied"
+
+	// DryRunAnnotation set to "true" on a resource only plans its changes instead of applying them
+	DryRunAnnotation = "appoptics.io/dry-run"
+
+	// PausedAnnotation set to "true" on a resource stops the controller from changing it in AppOptics,
+	// apart from deleting it
+	PausedAnnotation = "appoptics.io/paused"
+
+	// MetricDeletionAnnotation set to "true" on an AppOpticsMetric or AppOpticsCompositeMetric deletes
+	// the metric, and all of its data, from AppOptics when the resource is deleted
+	MetricDeletionAnnotation = "appoptics.io/delete-metric"
+
+	// MetricLeft is used as part of the Event 'reason' when a metric renamed in the spec is left in
+	// AppOptics
+	MetricLeft = "MetricLeft"
+
+	// MessageMetricLeft is the message used for the MetricLeft Event
+	MessageMetricLeft = "Metric %q is no longer managed by this resource and was left in AppOptics"
+
+	// Paused is used as part of the Event 'reason' when reconciliation of a resource is paused
+	Paused = "Paused"
+
+	// Resumed is used as part of the Event 'reason' when reconciliation of a resource is resumed
+	Resumed = "Resumed"
+
+	// MessagePaused is the message used for the Paused condition
+	MessagePaused = "Paused, %d changes not applied"
+
+	// MetricsFound is used as the reason of the MetricsFound condition when every metric exists
+	MetricsFound = "MetricsFound"
+
+	// UnknownMetrics is used as part of the Event 'reason' when a dashboard or alert uses metrics that
+	// do not exist in AppOptics
+	UnknownMetrics = "UnknownMetrics"
+
+	// MessageUnknownMetrics is the message used for Events when a dashboard or alert uses unknown metrics
+	MessageUnknownMetrics = "Metrics not found in AppOptics: %s"
+
 	Dashboard = "Dashboard"
 	Alert     = "Alert"
 	Service   = "Service"
+	Metric    = "Metric"
+	// CompositeMetric is an AppOpticsCompositeMetric
+	CompositeMetric = "CompositeMetric"
 )
 
 type CommonAOResource struct {
@@ -48,216 +101,249 @@ func (c *Controller) syncHandler(key string) error {
 	}
 	currentTime := time.Now()
 
-	switch kind {
-	case Dashboard:
-		dashboard, err := c.dashboardLister.AppOpticsDashboards(namespace).Get(name)
-		if err != nil {
-			if errors.IsNotFound(err) {
-				runtime.HandleError(fmt.Errorf("%s '%s' in work queue no longer exists", kind, key))
-				return nil
-			}
-
-			return err
-		}
+	if kind == Deployment || kind == StatefulSet {
+		return c.syncWorkload(kind, namespace, name)
+	}
 
-		if len(dashboard.Status.LastUpdated) > 0 {
-			lastUpdated, err := time.Parse(DateFormat, dashboard.Status.LastUpdated)
-			if err != nil {
-				glog.Warningf("Error, date %s not in RFC1123Z format")
-			} else {
-				if currentTime.Unix()-lastUpdated.Unix() < c.resyncTime {
-					return nil
-				}
-			}
+	// NEVER modify objects from the store. getResource hands back a copy of the local cache.
+	aoResource, err := c.getResource(kind, namespace, name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			runtime.HandleError(fmt.Errorf("%s '%s' in work queue no longer exists", kind, key))
+			return nil
 		}
 
-		// NEVER modify objects from the store. It's a read-only, local cache.
-		updateStatus := dashboard.Status.DeepCopy()
-		updateStatus.LastUpdated = currentTime.Format(DateFormat)
-
-		aoResource := CommonAOResource(*dashboard)
+		return err
+	}
 
-		secret, err := c.kubeclientset.CoreV1().Secrets(namespace).Get(aoResource.Spec.Secret, metav1.GetOptions{})
+	settings := c.settings()
+	forced := c.forcedRequests(key) > 0
+	if !forced && len(aoResource.Status.LastUpdated) > 0 {
+		lastUpdated, err := time.Parse(DateFormat, aoResource.Status.LastUpdated)
 		if err != nil {
-			return err
+			glog.Warningf("Error, date %s not in RFC1123Z format", aoResource.Status.LastUpdated)
+		} else {
+			if currentTime.Sub(lastUpdated) < settings.Resync.Duration {
+				return nil
+			}
 		}
+	}
 
-		aoc, err := c.GetCommunicator(secret)
-		if err != nil {
-			return err
-		}
+	secret, err := c.kubeclientset.CoreV1().Secrets(namespace).Get(c.secretName(aoResource.Spec), metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
 
-		if aoResource.DeletionTimestamp != nil {
-			err = aoc.Remove(aoResource.Status.ID, kind)
-			if err != nil {
-				return err
-			}
-			c.finalizers(&aoResource, remove)
+	aoc, err := c.GetCommunicator(secret)
+	if err != nil {
+		return err
+	}
+	aoc.Plan.DryRun = settings.DryRun || aoResource.Annotations[DryRunAnnotation] == "true"
+	aoc.Owner = appoptics.Ownership{
+		ClusterID: c.clusterID,
+		Namespace: aoResource.Namespace,
+		Name:      aoResource.Name,
+		UID:       string(aoResource.UID),
+	}
 
-			uDashboard := v12.AppOpticsDashboard(aoResource)
-			_, err := c.aoclientset.AppopticsV1().AppOpticsDashboards(namespace).Update(&uDashboard)
+	if aoResource.DeletionTimestamp != nil {
+		// With the Retain policy only the finalizer is removed, the AppOptics resource is left as it is
+		if settings.DeletionPolicy != config.DeletionPolicyRetain && removable(kind, aoResource) {
+			err = aoc.Remove(&aoResource.Status, kind)
 			if err != nil {
 				return err
 			}
-			return nil
 		}
-
-		c.finalizers(&aoResource, add)
-		updateStatus, err = aoc.Sync(aoResource.Spec, updateStatus, kind, nil)
-		if err != nil {
-			return err
+		if aoc.Plan.DryRun {
+			// Keep the finalizer so the AppOptics resource is still removed once the dry run ends
+			return c.recordPlan(kind, aoResource, aoResource.Status.DeepCopy(), currentTime, aoc.Plan.Changes)
 		}
+		c.finalizers(aoResource, remove)
 
-		udashboard := v12.AppOpticsDashboard(aoResource)
-		dashboardCopy := udashboard.DeepCopy()
-
-		dashboardCopy.Status = *updateStatus
-
-		_, err = c.aoclientset.AppopticsV1().AppOpticsDashboards(dashboard.Namespace).Update(dashboardCopy)
-		if err != nil {
-			c.recorder.Event(dashboard, v1.EventTypeWarning, ErrUpdateStatus, err.Error())
-		} else {
-			c.recorder.Event(dashboardCopy, v1.EventTypeNormal, SuccessUpdate, MessageResourceUpdated)
-		}
-	case Service:
-		service, err := c.serviceLister.AppOpticsServices(namespace).Get(name)
-		if err != nil {
-			if errors.IsNotFound(err) {
-				runtime.HandleError(fmt.Errorf("%s '%s' in work queue no longer exists", kind, key))
-				return nil
-			}
+		return c.updateResource(kind, aoResource)
+	}
 
-			return err
-		}
+	// A permanent failure is not retried until the spec changes. Without a status subresource every
+	// status write bumps the generation, so the failed spec is recognised by its hash instead.
+	specHash, err := appoptics.Hash(aoResource.Spec)
+	if err != nil {
+		return err
+	}
+	synced := aoResource.Status.GetCondition(v12.ConditionSynced)
+	if synced != nil && synced.Status == v12.ConditionFalse && synced.Reason == ErrInvalidSpec &&
+		bytes.Equal(aoResource.Status.Hashes.InvalidSpec, specHash) {
+		return nil
+	}
 
-		if len(service.Status.LastUpdated) > 0 {
-			lastUpdated, err := time.Parse(DateFormat, service.Status.LastUpdated)
-			if err != nil {
-				glog.Warningf("Error, date %s not in RFC1123Z format")
-			} else {
-				if currentTime.Unix()-lastUpdated.Unix() < c.resyncTime {
-					return nil
-				}
-			}
-		}
-		// NEVER modify objects from the store. It's a read-only, local cache.
-		updateStatus := service.Status.DeepCopy()
-		updateStatus.LastUpdated = currentTime.Format(DateFormat)
-		aoResource := CommonAOResource(*service)
+	// A paused resource is synced like a dry run, which finds the drift between the spec and AppOptics
+	paused := aoResource.Annotations[PausedAnnotation] == "true"
+	if paused {
+		aoc.Plan.DryRun = true
+	}
 
-		secret, err := c.kubeclientset.CoreV1().Secrets(namespace).Get(aoResource.Spec.Secret, metav1.GetOptions{})
+	syncContext := appoptics.SyncContext{
+		Namespace:        namespace,
+		Services:         c.serviceLister,
+		CompositeMetrics: c.compositeLister.AppOpticsCompositeMetrics(namespace),
+		Secrets:          c.kubeclientset.CoreV1().Secrets(namespace),
+	}
+	if kind == Alert {
+		syncContext.SameAccount = c.sameAccount(secret)
+		syncContext.MaintenanceWindows, err = c.openMaintenanceWindows(aoResource, currentTime)
 		if err != nil {
 			return err
 		}
+	}
+	updateStatus := aoResource.Status.DeepCopy()
+	updateStatus.LastUpdated = currentTime.Format(DateFormat)
+	updateStatus.ObservedGeneration = aoResource.Generation
 
-		aoc, err := c.GetCommunicator(secret)
+	if aoc.Plan.DryRun {
+		// Only the plan is kept, the status must keep describing what is really in AppOptics
+		_, err = aoc.Sync(aoResource.Spec, updateStatus.DeepCopy(), kind, syncContext)
 		if err != nil {
-			return err
-		}
-
-		if aoResource.DeletionTimestamp != nil {
-			err = aoc.Remove(aoResource.Status.ID, kind)
-			if err != nil {
-				return err
-			}
-			c.finalizers(&aoResource, remove)
-
-			uService := v12.AppOpticsService(aoResource)
-			_, err := c.aoclientset.AppopticsV1().AppOpticsServices(namespace).Update(&uService)
-			if err != nil {
+			if !appoptics.IsPermanentError(err) {
 				return err
 			}
-			return nil
+			failedStatus := aoResource.Status.DeepCopy()
+			failedStatus.LastUpdated = updateStatus.LastUpdated
+			failedStatus.ObservedGeneration = updateStatus.ObservedGeneration
+			return c.recordInvalidSpec(kind, aoResource, failedStatus, err)
 		}
-
-		c.finalizers(&aoResource, add)
-		updateStatus, err = aoc.Sync(aoResource.Spec, updateStatus, kind, nil)
-		if err != nil {
-			return err
+		if paused {
+			return c.recordPaused(kind, aoResource, aoResource.Status.DeepCopy(), currentTime, aoc.Plan.Changes)
 		}
+		return c.recordPlan(kind, aoResource, aoResource.Status.DeepCopy(), currentTime, aoc.Plan.Changes)
+	}
 
-		uService := v12.AppOpticsService(aoResource)
-		serviceCopy := uService.DeepCopy()
-		serviceCopy.Status = *updateStatus
-
-		_, err = c.aoclientset.AppopticsV1().AppOpticsServices(service.Namespace).Update(serviceCopy)
-		if err != nil {
-			c.recorder.Event(service, v1.EventTypeWarning, ErrUpdateStatus, err.Error())
-		} else {
-			c.recorder.Event(serviceCopy, v1.EventTypeNormal, SuccessUpdate, MessageResourceUpdated)
-		}
-	case Alert:
-		alert, err := c.alertLister.AppOpticsAlerts(namespace).Get(name)
-		if err != nil {
-			if errors.IsNotFound(err) {
-				runtime.HandleError(fmt.Errorf("%s '%s' in work queue no longer exists", kind, key))
-				return nil
-			}
-
+	c.finalizers(aoResource, add)
+	syncContext.Checkpoint = c.checkpoint(kind, aoResource)
+	updateStatus.PlannedChanges = nil
+	resumed := updateStatus.GetCondition(v12.ConditionPaused) != nil
+	updateStatus.RemoveCondition(v12.ConditionPaused)
+	syncedStatus, err := aoc.Sync(aoResource.Spec, updateStatus, kind, syncContext)
+	if err != nil {
+		if !appoptics.IsPermanentError(err) {
 			return err
 		}
+		// Sync updates the status in place, so anything it created before failing is kept
+		return c.recordInvalidSpec(kind, aoResource, updateStatus, err)
+	}
 
-		if len(alert.Status.LastUpdated) > 0 {
-			lastUpdated, err := time.Parse(DateFormat, alert.Status.LastUpdated)
-			if err != nil {
-				glog.Warningf("Error, date %s not in RFC1123Z format")
-			} else {
-				if currentTime.Unix()-lastUpdated.Unix() < c.resyncTime {
-					return nil
-				}
-			}
-		}
+	syncedStatus.SetCondition(v12.ConditionSynced, v12.ConditionTrue, SuccessUpdate, "")
+	syncedStatus.Hashes.InvalidSpec = nil
+	if settings.CheckMetrics && (kind == Dashboard || kind == Alert) {
+		c.checkMetricCatalog(&aoc, kind, aoResource, syncedStatus, syncContext)
+	} else {
+		syncedStatus.RemoveCondition(v12.ConditionMetricsFound)
+	}
+	if (kind == Metric || kind == CompositeMetric) && aoResource.Status.Name != "" && syncedStatus.Name != aoResource.Status.Name {
+		c.recorder.Event(toObject(kind, aoResource), v1.EventTypeWarning, MetricLeft, fmt.Sprintf(MessageMetricLeft, aoResource.Status.Name))
+	}
+	aoResource.Status = *syncedStatus
 
-		// NEVER modify objects from the store. It's a read-only, local cache.
-		updateStatus := alert.Status.DeepCopy()
-		updateStatus.LastUpdated = currentTime.Format(DateFormat)
-		aoResource := CommonAOResource(*alert)
+	err = c.updateResource(kind, aoResource)
+	if err == nil && kind == Alert {
+		err = c.recordMaintenanceWindows(aoResource, syncContext.MaintenanceWindows)
+	}
+	if err != nil {
+		// The status was not stored, so the resource is synced again rather than left out of step
+		c.recorder.Event(toObject(kind, aoResource), v1.EventTypeWarning, ErrUpdateStatus, err.Error())
+		return err
+	}
+	if resumed {
+		c.recorder.Event(toObject(kind, aoResource), v1.EventTypeNormal, Resumed, "Reconciliation resumed")
+	}
+	c.recorder.Event(toObject(kind, aoResource), v1.EventTypeNormal, SuccessUpdate, MessageResourceUpdated)
 
-		secret, err := c.kubeclientset.CoreV1().Secrets(namespace).Get(aoResource.Spec.Secret, metav1.GetOptions{})
-		if err != nil {
-			return err
-		}
+	return nil
+}
 
-		aoc, err := c.GetCommunicator(secret)
+// checkpoint returns the Checkpoint storing the ID of a newly created AppOptics object in the
+// resource's status, along with the finalizer, before the sync goes on. Should the sync then fail, or
+// the controller stop, the next sync finds the object by its ID rather than creating another.
+func (c *Controller) checkpoint(kind string, aoResource *CommonAOResource) appoptics.Checkpoint {
+	return func(ID int) error {
+		aoResource.Status.ID = ID
+		err := c.updateResource(kind, aoResource)
 		if err != nil {
-			return err
+			return fmt.Errorf("error storing the ID %d of %s %s/%s: %s", ID, kind, aoResource.Namespace, aoResource.Name, err.Error())
 		}
+		return nil
+	}
+}
 
-		if aoResource.DeletionTimestamp != nil {
-			err = aoc.Remove(aoResource.Status.ID, kind)
-			if err != nil {
-				return err
-			}
-			c.finalizers(&aoResource, remove)
+// removable reports whether deleting the resource deletes its object from AppOptics. A metric holds its
+// whole history and may also be reported by agents or other clusters under the same name, so it is
+// only deleted when the resource opts in.
+func removable(kind string, aoResource *CommonAOResource) bool {
+	if kind != Metric && kind != CompositeMetric {
+		return true
+	}
+	return aoResource.Annotations[MetricDeletionAnnotation] == "true"
+}
 
-			uAlerts := v12.AppOpticsAlert(aoResource)
-			_, err := c.aoclientset.AppopticsV1().AppOpticsAlerts(namespace).Update(&uAlerts)
-			if err != nil {
-				return err
-			}
-			return nil
-		}
+// recordInvalidSpec marks the resource as failed for its current generation so it is not retried
+// until the spec changes
+func (c *Controller) recordInvalidSpec(kind string, aoResource *CommonAOResource, status *v12.Status, err error) error {
+	status.SetCondition(v12.ConditionSynced, v12.ConditionFalse, ErrInvalidSpec, err.Error())
+	specHash, hashErr := appoptics.Hash(aoResource.Spec)
+	if hashErr != nil {
+		return hashErr
+	}
+	status.Hashes.InvalidSpec = specHash
+	aoResource.Status = *status
+	c.recorder.Event(toObject(kind, aoResource), v1.EventTypeWarning, ErrInvalidSpec, err.Error())
+	return c.updateResource(kind, aoResource)
+}
 
-		c.finalizers(&aoResource, add)
-		updateStatus, err = aoc.Sync(aoResource.Spec, updateStatus, kind, c.serviceLister.AppOpticsServices(namespace))
-		if err != nil {
-			return err
-		}
+// recordPlan stores the changes a dry run would have made in the status and reports each as an Event
+func (c *Controller) recordPlan(kind string, aoResource *CommonAOResource, status *v12.Status, currentTime time.Time, changes []string) error {
+	status.LastUpdated = currentTime.Format(DateFormat)
+	status.PlannedChanges = changes
+	status.SetCondition(v12.ConditionSynced, v12.ConditionUnknown, DryRunPlanned, fmt.Sprintf(MessageDryRunPlanned, len(changes)))
+	aoResource.Status = *status
 
-		uAlert := v12.AppOpticsAlert(aoResource)
-		alertCopy := uAlert.DeepCopy()
+	obj := toObject(kind, aoResource)
+	for _, change := range changes {
+		c.recorder.Event(obj, v1.EventTypeNormal, DryRunPlanned, change)
+	}
+	return c.updateResource(kind, aoResource)
+}
 
-		alertCopy.Status = *updateStatus
+// checkMetricCatalog reports the metrics used by a dashboard or alert that do not exist in AppOptics.
+// It never fails the sync, the metrics may only be reported after the resources using them are created.
+func (c *Controller) checkMetricCatalog(aoc *appoptics.AOCommunicator, kind string, aoResource *CommonAOResource, status *v12.Status, syncContext appoptics.SyncContext) {
+	unknown, err := aoc.UnknownMetrics(aoResource.Spec, kind, syncContext)
+	if err != nil {
+		glog.Warningf("Error checking the metrics of %s %s/%s: %s", kind, aoResource.Namespace, aoResource.Name, err.Error())
+		return
+	}
+	if len(unknown) == 0 {
+		status.SetCondition(v12.ConditionMetricsFound, v12.ConditionTrue, MetricsFound, "")
+		return
+	}
 
-		_, err = c.aoclientset.AppopticsV1().AppOpticsAlerts(alert.Namespace).Update(alertCopy)
-		if err != nil {
-			c.recorder.Event(alert, v1.EventTypeWarning, ErrUpdateStatus, err.Error())
-		} else {
-			c.recorder.Event(alertCopy, v1.EventTypeNormal, SuccessUpdate, MessageResourceUpdated)
-		}
+	message := fmt.Sprintf(MessageUnknownMetrics, strings.Join(unknown, ", "))
+	previous := status.GetCondition(v12.ConditionMetricsFound)
+	if previous == nil || previous.Message != message {
+		c.recorder.Event(toObject(kind, aoResource), v1.EventTypeWarning, UnknownMetrics, message)
 	}
+	status.SetCondition(v12.ConditionMetricsFound, v12.ConditionFalse, UnknownMetrics, message)
+}
 
-	return nil
+// recordPaused stores the changes a paused resource has drifted by in the status
+func (c *Controller) recordPaused(kind string, aoResource *CommonAOResource, status *v12.Status, currentTime time.Time, changes []string) error {
+	wasPaused := status.IsConditionTrue(v12.ConditionPaused)
+	status.LastUpdated = currentTime.Format(DateFormat)
+	status.PlannedChanges = changes
+	status.SetCondition(v12.ConditionPaused, v12.ConditionTrue, Paused, fmt.Sprintf(MessagePaused, len(changes)))
+	aoResource.Status = *status
+
+	if !wasPaused {
+		c.recorder.Event(toObject(kind, aoResource), v1.EventTypeNormal, Paused, "Reconciliation paused")
+	}
+	return c.updateResource(kind, aoResource)
 }
 
 func (c *Controller) GetCommunicator(secret *v1.Secret) (appoptics.AOCommunicator, error) {
@@ -267,5 +353,29 @@ func (c *Controller) GetCommunicator(secret *v1.Secret) (appoptics.AOCommunicato
 	} else {
 		return appoptics.AOCommunicator{}, errors.NewNotFound(schema.GroupResource{}, "token")
 	}
-	return appoptics.NewAOCommunicator(aoClientToken), nil
+	return appoptics.NewAOCommunicator(aoClientToken, c.settings().APIURL), nil
+}
+
+// sameAccount returns the check that an AppOpticsService uses the AppOptics token of the Secret
+func (c *Controller) sameAccount(secret *v1.Secret) appoptics.AccountChecker {
+	return func(service *v12.AppOpticsService) (bool, error) {
+		name := c.secretName(service.Spec)
+		if service.Namespace == secret.Namespace && name == secret.Name {
+			return true, nil
+		}
+		serviceSecret, err := c.kubeclientset.CoreV1().Secrets(service.Namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return bytes.Equal(serviceSecret.Data["token"], secret.Data["token"]), nil
+	}
+}
+
+// secretName returns the Secret holding the AppOptics token of a resource, the default secret
+// when its spec does not name one
+func (c *Controller) secretName(spec v12.TokenAndDataSpec) string {
+	if spec.Secret != "" {
+		return spec.Secret
+	}
+	return c.settings().DefaultSecret
 }
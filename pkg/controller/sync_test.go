@@ -0,0 +1,158 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	v12 "github.com/solarwinds/appoptics-kubernetes-controller/pkg/apis/appoptics-kubernetes-controller/v1"
+	"github.com/solarwinds/appoptics-kubernetes-controller/pkg/config"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// testMetricsAPI serves the AppOptics metrics endpoints and records every request that changes a metric
+type testMetricsAPI struct {
+	*httptest.Server
+	sync.Mutex
+	metrics map[string]bool
+	writes  []string
+}
+
+func (api *testMetricsAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/v1/metrics/")
+	api.Lock()
+	defer api.Unlock()
+	if r.Method != http.MethodGet {
+		api.writes = append(api.writes, r.Method+" "+name)
+	}
+	switch r.Method {
+	case http.MethodGet:
+		if !api.metrics[name] {
+			http.Error(w, `{"errors":{"request":["Not Found"]}}`, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"name": "` + name + `", "type": "gauge", "attributes": {}}`))
+	case http.MethodPut:
+		api.metrics[name] = true
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		delete(api.metrics, name)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (api *testMetricsAPI) changes() []string {
+	api.Lock()
+	defer api.Unlock()
+	return append([]string(nil), api.writes...)
+}
+
+// newTestSyncController returns a controller syncing against a fake AppOptics metrics API, with the
+// metric and the token Secret of its namespace stored. Every event syncs, the resync period is 0.
+// The API must be closed by the test.
+func newTestSyncController(deletionPolicy string, metric *v12.AppOpticsMetric) (*Controller, *testMetricsAPI) {
+	api := &testMetricsAPI{metrics: map[string]bool{}}
+	api.Server = httptest.NewServer(api)
+
+	cfg := config.Default()
+	cfg.APIURL = api.URL + "/v1/"
+	cfg.Resync = metav1.Duration{}
+	cfg.DeletionPolicy = deletionPolicy
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: metric.Namespace, Name: cfg.DefaultSecret},
+		Data:       map[string][]byte{"token": []byte("deadbeef")},
+	}
+	return newTestController(cfg, secret, metric), api
+}
+
+func newTestMetric(annotations map[string]string) *v12.AppOpticsMetric {
+	return &v12.AppOpticsMetric{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "requests", Annotations: annotations},
+		Spec:       v12.TokenAndDataSpec{Data: "name: web.requests\ntype: gauge\n"},
+	}
+}
+
+// storedMetric gets the metric from the fake API server and puts it in the controller's cache, as its
+// informer would after a write
+func storedMetric(t *testing.T, c *Controller) *v12.AppOpticsMetric {
+	metric, err := c.aoclientset.AppopticsV1().AppOpticsMetrics("web").Get("requests", metav1.GetOptions{})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	c.informers[testInformerIndex(metric)].GetIndexer().Update(metric)
+	return metric
+}
+
+func TestPausedResourceRecordsPlannedChanges(t *testing.T) {
+	c, api := newTestSyncController(config.DeletionPolicyDelete, newTestMetric(map[string]string{PausedAnnotation: "true"}))
+	defer api.Close()
+
+	err := c.syncHandler("web/Metric/requests")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(api.changes()))
+
+	metric := storedMetric(t, c)
+	assert.Equal(t, []string{`update metric "web.requests"`}, metric.Status.PlannedChanges)
+	assert.True(t, metric.Status.IsConditionTrue(v12.ConditionPaused))
+	assert.Equal(t, "", metric.Status.Name)
+	assert.Equal(t, 0, len(metric.Finalizers))
+	assert.Equal(t, []string{"Normal Paused Reconciliation paused"}, testEvents(c))
+
+	// Still paused, the condition is kept without reporting the pause again
+	err = c.syncHandler("web/Metric/requests")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(api.changes()))
+	assert.True(t, storedMetric(t, c).Status.IsConditionTrue(v12.ConditionPaused))
+	assert.Equal(t, 0, len(testEvents(c)))
+}
+
+func TestResumedResourceClearsPausedCondition(t *testing.T) {
+	c, api := newTestSyncController(config.DeletionPolicyDelete, newTestMetric(map[string]string{PausedAnnotation: "true"}))
+	defer api.Close()
+	assert.Nil(t, c.syncHandler("web/Metric/requests"))
+	testEvents(c)
+
+	metric := storedMetric(t, c)
+	metric.Annotations = nil
+	metric, err := c.aoclientset.AppopticsV1().AppOpticsMetrics("web").Update(metric)
+	assert.Nil(t, err)
+	c.informers[testInformerIndex(metric)].GetIndexer().Update(metric)
+
+	err = c.syncHandler("web/Metric/requests")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"PUT web.requests"}, api.changes())
+
+	metric = storedMetric(t, c)
+	assert.Nil(t, metric.Status.GetCondition(v12.ConditionPaused))
+	assert.Equal(t, 0, len(metric.Status.PlannedChanges))
+	assert.Equal(t, "web.requests", metric.Status.Name)
+	assert.Equal(t, []string{AppopticsFinalizer}, metric.Finalizers)
+	assert.Contains(t, testEvents(c), "Normal Resumed Reconciliation resumed")
+}
+
+func TestPausedResourceIsStillDeleted(t *testing.T) {
+	for _, policy := range []string{config.DeletionPolicyDelete, config.DeletionPolicyRetain} {
+		deleted := metav1.NewTime(time.Now())
+		metric := newTestMetric(map[string]string{PausedAnnotation: "true", MetricDeletionAnnotation: "true"})
+		metric.DeletionTimestamp = &deleted
+		metric.Finalizers = []string{AppopticsFinalizer}
+		metric.Status.Name = "web.requests"
+		c, api := newTestSyncController(policy, metric)
+		defer api.Close()
+
+		err := c.syncHandler("web/Metric/requests")
+		assert.Nil(t, err)
+		if policy == config.DeletionPolicyDelete {
+			assert.Equal(t, []string{"DELETE web.requests"}, api.changes())
+		} else {
+			assert.Equal(t, 0, len(api.changes()))
+		}
+		assert.Equal(t, 0, len(storedMetric(t, c).Finalizers))
+	}
+}
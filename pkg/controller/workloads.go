@@ -0,0 +1,329 @@
+package controller
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	v12 "github.com/solarwinds/appoptics-kubernetes-controller/pkg/apis/appoptics-kubernetes-controller/v1"
+	"github.com/solarwinds/appoptics-kubernetes-controller/pkg/controller/appoptics"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+)
+
+const (
+	// Deployment and StatefulSet are the workloads AppOptics resources are generated for
+	Deployment  = "Deployment"
+	StatefulSet = "StatefulSet"
+
+	// DashboardTemplateAnnotation on a workload names the template of the dashboard generated for it
+	DashboardTemplateAnnotation = "appoptics.io/dashboard-template"
+
+	// SecretAnnotation on a workload names the Secret with the AppOptics token of the resources
+	// generated for it, the controller's default secret when it is not set
+	SecretAnnotation = "appoptics.io/secret"
+
+	// BaselineAlertsAnnotation set to "true" on a workload generates its baseline alerts with the
+	// default thresholds
+	BaselineAlertsAnnotation = "appoptics.io/baseline-alerts"
+
+	// AlertPolicyAnnotation on a workload names the AppOpticsAlertPolicy its baseline alerts are
+	// generated with, which also turns them on
+	AlertPolicyAnnotation = "appoptics.io/alert-policy"
+
+	// RestartsAnnotation, CPUThrottlingAnnotation and MemoryAnnotation on a workload override the
+	// thresholds of its baseline alerts
+	RestartsAnnotation      = "appoptics.io/alert-restarts"
+	CPUThrottlingAnnotation = "appoptics.io/alert-cpu-throttling"
+	MemoryAnnotation        = "appoptics.io/alert-memory"
+
+	// ErrGenerate is used as part of the Event 'reason' when the resources of a workload can not be generated
+	ErrGenerate = "ErrGenerate"
+)
+
+// workload returns the Deployment or StatefulSet from the cache
+func (c *Controller) workload(kind, namespace, name string) (metav1.Object, k8sruntime.Object, error) {
+	switch kind {
+	case Deployment:
+		deployment, err := c.deployLister.Deployments(namespace).Get(name)
+		return deployment, deployment, err
+	case StatefulSet:
+		statefulSet, err := c.statefulLister.StatefulSets(namespace).Get(name)
+		return statefulSet, statefulSet, err
+	}
+	return nil, nil, fmt.Errorf("unknown workload kind %s", kind)
+}
+
+// workloadOwnerReference makes the workload the controller of a generated resource, so Kubernetes
+// deletes the resource, and the controller removes it from AppOptics, when the workload is deleted
+func workloadOwnerReference(kind string, workload metav1.Object) metav1.OwnerReference {
+	return *metav1.NewControllerRef(workload, appsv1.SchemeGroupVersion.WithKind(kind))
+}
+
+// workloadSelector returns the matchLabels of the selector of a Deployment or StatefulSet
+func workloadSelector(obj k8sruntime.Object) map[string]string {
+	var selector *metav1.LabelSelector
+	switch workload := obj.(type) {
+	case *appsv1.Deployment:
+		selector = workload.Spec.Selector
+	case *appsv1.StatefulSet:
+		selector = workload.Spec.Selector
+	}
+	if selector == nil {
+		return nil
+	}
+	return selector.MatchLabels
+}
+
+// generatedName is the name of a resource generated for a workload
+func generatedName(kind, name string) string {
+	return strings.ToLower(kind) + "-" + name
+}
+
+// syncWorkload creates, updates or deletes the resources generated for a workload from its annotations
+func (c *Controller) syncWorkload(kind, namespace, name string) error {
+	workload, obj, err := c.workload(kind, namespace, name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// The garbage collector deletes the generated resources through their owner reference
+			return nil
+		}
+		return err
+	}
+	err = c.syncWorkloadDashboard(kind, workload, obj)
+	if err != nil {
+		return err
+	}
+	return c.syncWorkloadAlerts(kind, workload, obj)
+}
+
+// syncWorkloadDashboard keeps the AppOpticsDashboard generated from the dashboard template of a
+// workload in step with it. Dashboards with the same name that the workload does not own are left alone.
+func (c *Controller) syncWorkloadDashboard(kind string, workload metav1.Object, obj k8sruntime.Object) error {
+	dashboardName := generatedName(kind, workload.GetName())
+	existing, err := c.dashboardLister.AppOpticsDashboards(workload.GetNamespace()).Get(dashboardName)
+	if errors.IsNotFound(err) {
+		existing = nil
+	} else if err != nil {
+		return err
+	}
+	if existing != nil && !metav1.IsControlledBy(existing, workload) {
+		c.recorder.Eventf(obj, corev1.EventTypeWarning, ErrGenerate, "AppOpticsDashboard %s exists and is not owned by this %s", dashboardName, kind)
+		return nil
+	}
+
+	template := workload.GetAnnotations()[DashboardTemplateAnnotation]
+	if template == "" {
+		if existing == nil {
+			return nil
+		}
+		return c.aoclientset.AppopticsV1().AppOpticsDashboards(workload.GetNamespace()).Delete(dashboardName, &metav1.DeleteOptions{})
+	}
+
+	data, err := appoptics.WorkloadDashboard(template, appoptics.Workload{Kind: kind, Namespace: workload.GetNamespace(), Name: workload.GetName(), Selector: workloadSelector(obj)})
+	if err != nil {
+		c.recorder.Event(obj, corev1.EventTypeWarning, ErrGenerate, err.Error())
+		return nil
+	}
+	spec := v12.TokenAndDataSpec{
+		Namespace: workload.GetNamespace(),
+		Secret:    workloadSecret(workload),
+		Data:      data,
+	}
+
+	if existing == nil {
+		dashboard := &v12.AppOpticsDashboard{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            dashboardName,
+				Namespace:       workload.GetNamespace(),
+				OwnerReferences: []metav1.OwnerReference{workloadOwnerReference(kind, workload)},
+			},
+			Spec: spec,
+		}
+		_, err = c.aoclientset.AppopticsV1().AppOpticsDashboards(workload.GetNamespace()).Create(dashboard)
+		return err
+	}
+	if reflect.DeepEqual(existing.Spec, spec) {
+		return nil
+	}
+	dashboard := existing.DeepCopy()
+	dashboard.Spec = spec
+	_, err = c.aoclientset.AppopticsV1().AppOpticsDashboards(workload.GetNamespace()).Update(dashboard)
+	return err
+}
+
+// syncWorkloadAlerts keeps the baseline AppOpticsAlerts of a workload in step with its annotations
+// and alert policy. Alerts turned off, or all of them when the workload opts out, are deleted.
+func (c *Controller) syncWorkloadAlerts(kind string, workload metav1.Object, obj k8sruntime.Object) error {
+	annotations := workload.GetAnnotations()
+	policyName := annotations[AlertPolicyAnnotation]
+
+	var wanted map[string]string
+	secret := workloadSecret(workload)
+	var serviceRefs []v12.ObjectReference
+	if policyName != "" || annotations[BaselineAlertsAnnotation] == "true" {
+		thresholds := appoptics.DefaultBaselineThresholds
+		if policyName != "" {
+			policy, err := c.policyLister.AppOpticsAlertPolicies(workload.GetNamespace()).Get(policyName)
+			if errors.IsNotFound(err) {
+				// Alerts are not left on the thresholds of a deleted policy, they fall back to the
+				// defaults until the policy is created again and the workload synced with it
+				c.recorder.Eventf(obj, corev1.EventTypeWarning, ErrGenerate, "AppOpticsAlertPolicy %s not found, using the default thresholds", policyName)
+			} else if err != nil {
+				return err
+			} else {
+				applyAlertPolicy(&thresholds, policy.Spec)
+				if policy.Spec.Secret != "" {
+					secret = policy.Spec.Secret
+				}
+				serviceRefs = policy.Spec.ServiceRefs
+			}
+		}
+		err := applyThresholdAnnotations(&thresholds, annotations)
+		if err != nil {
+			c.recorder.Event(obj, corev1.EventTypeWarning, ErrGenerate, err.Error())
+			return nil
+		}
+		wanted, err = appoptics.WorkloadAlerts(appoptics.Workload{Kind: kind, Namespace: workload.GetNamespace(), Name: workload.GetName(), Selector: workloadSelector(obj)}, thresholds)
+		if err != nil {
+			c.recorder.Event(obj, corev1.EventTypeWarning, ErrGenerate, err.Error())
+			return nil
+		}
+	}
+
+	alerts := c.aoclientset.AppopticsV1().AppOpticsAlerts(workload.GetNamespace())
+	for _, suffix := range appoptics.BaselineAlertSuffixes() {
+		alertName := generatedName(kind, workload.GetName()) + "-" + suffix
+		existing, err := c.alertLister.AppOpticsAlerts(workload.GetNamespace()).Get(alertName)
+		if errors.IsNotFound(err) {
+			existing = nil
+		} else if err != nil {
+			return err
+		}
+		if existing != nil && !metav1.IsControlledBy(existing, workload) {
+			c.recorder.Eventf(obj, corev1.EventTypeWarning, ErrGenerate, "AppOpticsAlert %s exists and is not owned by this %s", alertName, kind)
+			continue
+		}
+
+		data, ok := wanted[suffix]
+		if !ok {
+			if existing != nil {
+				err = alerts.Delete(alertName, &metav1.DeleteOptions{})
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		spec := v12.TokenAndDataSpec{
+			Namespace:   workload.GetNamespace(),
+			Secret:      secret,
+			Data:        data,
+			ServiceRefs: serviceRefs,
+		}
+
+		if existing == nil {
+			alert := &v12.AppOpticsAlert{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            alertName,
+					Namespace:       workload.GetNamespace(),
+					Annotations:     map[string]string{WorkloadAnnotation: strings.ToLower(kind) + "/" + workload.GetName()},
+					OwnerReferences: []metav1.OwnerReference{workloadOwnerReference(kind, workload)},
+				},
+				Spec: spec,
+			}
+			_, err = alerts.Create(alert)
+		} else if !reflect.DeepEqual(existing.Spec, spec) {
+			alert := existing.DeepCopy()
+			alert.Spec = spec
+			_, err = alerts.Update(alert)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyAlertPolicy sets the thresholds the policy sets
+func applyAlertPolicy(thresholds *appoptics.BaselineThresholds, policy v12.AlertPolicySpec) {
+	if policy.Restarts != nil {
+		thresholds.Restarts = *policy.Restarts
+	}
+	if policy.CPUThrottlingPercent != nil {
+		thresholds.CPUThrottlingPercent = *policy.CPUThrottlingPercent
+	}
+	if policy.MemoryPercent != nil {
+		thresholds.MemoryPercent = *policy.MemoryPercent
+	}
+}
+
+// applyThresholdAnnotations sets the thresholds the workload's annotations set, which take
+// precedence over its policy
+func applyThresholdAnnotations(thresholds *appoptics.BaselineThresholds, annotations map[string]string) error {
+	for annotation, threshold := range map[string]*int{
+		RestartsAnnotation:      &thresholds.Restarts,
+		CPUThrottlingAnnotation: &thresholds.CPUThrottlingPercent,
+		MemoryAnnotation:        &thresholds.MemoryPercent,
+	} {
+		value, ok := annotations[annotation]
+		if !ok {
+			continue
+		}
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			return fmt.Errorf("annotation %s must be a number of 0 or more, not %q", annotation, value)
+		}
+		*threshold = parsed
+	}
+	return nil
+}
+
+// enqueuePolicyWorkloads queues the workloads in the policy's namespace whose baseline alerts are
+// generated with the policy
+func (c *Controller) enqueuePolicyWorkloads(policy *v12.AppOpticsAlertPolicy) {
+	deployments, err := c.deployLister.Deployments(policy.Namespace).List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	for _, deployment := range deployments {
+		if deployment.Annotations[AlertPolicyAnnotation] == policy.Name {
+			c.enqueue(deployment, Deployment)
+		}
+	}
+	statefulSets, err := c.statefulLister.StatefulSets(policy.Namespace).List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	for _, statefulSet := range statefulSets {
+		if statefulSet.Annotations[AlertPolicyAnnotation] == policy.Name {
+			c.enqueue(statefulSet, StatefulSet)
+		}
+	}
+}
+
+// workloadSecret returns the Secret named by the workload's annotation, empty for the resources
+// generated for it to use the default secret
+func workloadSecret(workload metav1.Object) string {
+	return workload.GetAnnotations()[SecretAnnotation]
+}
+
+// enqueueOwningWorkload queues the workload that generated a dashboard or alert, so a generated
+// resource that is changed or deleted by hand is put back
+func (c *Controller) enqueueOwningWorkload(obj metav1.Object) {
+	owner := metav1.GetControllerOf(obj)
+	if owner == nil || owner.APIVersion != appsv1.SchemeGroupVersion.String() {
+		return
+	}
+	if owner.Kind == Deployment || owner.Kind == StatefulSet {
+		c.workqueue.Add(fmt.Sprintf("%s/%s/%s", obj.GetNamespace(), owner.Kind, owner.Name))
+	}
+}
@@ -0,0 +1,225 @@
+package controller
+
+import (
+	"testing"
+
+	v12 "github.com/solarwinds/appoptics-kubernetes-controller/pkg/apis/appoptics-kubernetes-controller/v1"
+	"github.com/solarwinds/appoptics-kubernetes-controller/pkg/config"
+	"github.com/solarwinds/appoptics-kubernetes-controller/pkg/controller/appoptics"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var testWorkloadSelector = map[string]string{"app": "web"}
+
+func newTestDeployment(annotations map[string]string) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "web", UID: types.UID("uid-1"), Annotations: annotations},
+		Spec:       appsv1.DeploymentSpec{Selector: &metav1.LabelSelector{MatchLabels: testWorkloadSelector}},
+	}
+}
+
+func testWorkload() appoptics.Workload {
+	return appoptics.Workload{Kind: Deployment, Namespace: "web", Name: "web", Selector: testWorkloadSelector}
+}
+
+// setTestWorkload puts the deployment in the controller's cache, as its informer would once it changed
+func setTestWorkload(c *Controller, deployment *appsv1.Deployment) {
+	c.informers[testInformerIndex(deployment)].GetIndexer().Update(deployment)
+}
+
+// cacheGenerated copies the dashboards and alerts stored in namespace web to the controller's cache,
+// as their informers would after the controller wrote them
+func cacheGenerated(t *testing.T, c *Controller) {
+	dashboards, err := c.aoclientset.AppopticsV1().AppOpticsDashboards("web").List(metav1.ListOptions{})
+	assert.Nil(t, err)
+	var cached []interface{}
+	for i := range dashboards.Items {
+		cached = append(cached, &dashboards.Items[i])
+	}
+	c.informers[testInformerIndex(&v12.AppOpticsDashboard{})].GetIndexer().Replace(cached, "")
+
+	alerts, err := c.aoclientset.AppopticsV1().AppOpticsAlerts("web").List(metav1.ListOptions{})
+	assert.Nil(t, err)
+	cached = nil
+	for i := range alerts.Items {
+		cached = append(cached, &alerts.Items[i])
+	}
+	c.informers[testInformerIndex(&v12.AppOpticsAlert{})].GetIndexer().Replace(cached, "")
+}
+
+// storedAlerts returns the alerts stored in namespace web by name
+func storedAlerts(t *testing.T, c *Controller) map[string]v12.AppOpticsAlert {
+	alerts, err := c.aoclientset.AppopticsV1().AppOpticsAlerts("web").List(metav1.ListOptions{})
+	assert.Nil(t, err)
+	byName := map[string]v12.AppOpticsAlert{}
+	for _, alert := range alerts.Items {
+		byName[alert.Name] = alert
+	}
+	return byName
+}
+
+// assertBaselineAlerts checks that the stored alerts are the baseline alerts of the deployment with
+// the thresholds, secret and services
+func assertBaselineAlerts(t *testing.T, c *Controller, thresholds appoptics.BaselineThresholds, secret string, serviceRefs []v12.ObjectReference) {
+	wanted, err := appoptics.WorkloadAlerts(testWorkload(), thresholds)
+	assert.Nil(t, err)
+	alerts := storedAlerts(t, c)
+	assert.Equal(t, len(wanted), len(alerts))
+	for suffix, data := range wanted {
+		alert, ok := alerts["deployment-web-"+suffix]
+		if !assert.True(t, ok, "alert %s", suffix) {
+			continue
+		}
+		assert.Equal(t, data, alert.Spec.Data)
+		assert.Equal(t, secret, alert.Spec.Secret)
+		assert.Equal(t, serviceRefs, alert.Spec.ServiceRefs)
+		assert.Equal(t, "deployment/web", alert.Annotations[WorkloadAnnotation])
+		assert.Equal(t, types.UID("uid-1"), metav1.GetControllerOf(&alert).UID)
+	}
+}
+
+func TestWorkloadDashboardCreatedUpdatedAndDeleted(t *testing.T) {
+	deployment := newTestDeployment(map[string]string{DashboardTemplateAnnotation: "resources"})
+	c := newTestController(config.Default(), deployment)
+
+	assert.Nil(t, c.syncWorkload(Deployment, "web", "web"))
+	dashboard, err := c.aoclientset.AppopticsV1().AppOpticsDashboards("web").Get("deployment-web", metav1.GetOptions{})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	data, err := appoptics.WorkloadDashboard("resources", testWorkload())
+	assert.Nil(t, err)
+	assert.Equal(t, data, dashboard.Spec.Data)
+	assert.True(t, metav1.IsControlledBy(dashboard, deployment))
+
+	cacheGenerated(t, c)
+	deployment = newTestDeployment(map[string]string{DashboardTemplateAnnotation: "default", SecretAnnotation: "team"})
+	setTestWorkload(c, deployment)
+	assert.Nil(t, c.syncWorkload(Deployment, "web", "web"))
+	dashboard, err = c.aoclientset.AppopticsV1().AppOpticsDashboards("web").Get("deployment-web", metav1.GetOptions{})
+	assert.Nil(t, err)
+	data, err = appoptics.WorkloadDashboard("default", testWorkload())
+	assert.Nil(t, err)
+	assert.Equal(t, data, dashboard.Spec.Data)
+	assert.Equal(t, "team", dashboard.Spec.Secret)
+
+	cacheGenerated(t, c)
+	setTestWorkload(c, newTestDeployment(nil))
+	assert.Nil(t, c.syncWorkload(Deployment, "web", "web"))
+	_, err = c.aoclientset.AppopticsV1().AppOpticsDashboards("web").Get("deployment-web", metav1.GetOptions{})
+	assert.True(t, errors.IsNotFound(err))
+}
+
+func TestWorkloadDashboardNotOwnedLeftAlone(t *testing.T) {
+	dashboard := &v12.AppOpticsDashboard{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "deployment-web"},
+		Spec:       v12.TokenAndDataSpec{Data: "name: Hand made\n"},
+	}
+	c := newTestController(config.Default(), newTestDeployment(map[string]string{DashboardTemplateAnnotation: "default"}), dashboard)
+
+	assert.Nil(t, c.syncWorkload(Deployment, "web", "web"))
+	stored, err := c.aoclientset.AppopticsV1().AppOpticsDashboards("web").Get("deployment-web", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, dashboard.Spec, stored.Spec)
+	assert.Equal(t, []string{"Warning ErrGenerate AppOpticsDashboard deployment-web exists and is not owned by this Deployment"}, testEvents(c))
+
+	setTestWorkload(c, newTestDeployment(nil))
+	assert.Nil(t, c.syncWorkload(Deployment, "web", "web"))
+	_, err = c.aoclientset.AppopticsV1().AppOpticsDashboards("web").Get("deployment-web", metav1.GetOptions{})
+	assert.Nil(t, err)
+}
+
+func TestWorkloadAlertsCreatedUpdatedAndDeleted(t *testing.T) {
+	c := newTestController(config.Default(), newTestDeployment(map[string]string{BaselineAlertsAnnotation: "true"}))
+
+	assert.Nil(t, c.syncWorkload(Deployment, "web", "web"))
+	assertBaselineAlerts(t, c, appoptics.DefaultBaselineThresholds, "", nil)
+
+	// A threshold of 0 turns its alert off
+	cacheGenerated(t, c)
+	setTestWorkload(c, newTestDeployment(map[string]string{BaselineAlertsAnnotation: "true", RestartsAnnotation: "10", MemoryAnnotation: "0"}))
+	assert.Nil(t, c.syncWorkload(Deployment, "web", "web"))
+	thresholds := appoptics.DefaultBaselineThresholds
+	thresholds.Restarts = 10
+	thresholds.MemoryPercent = 0
+	assertBaselineAlerts(t, c, thresholds, "", nil)
+
+	cacheGenerated(t, c)
+	setTestWorkload(c, newTestDeployment(nil))
+	assert.Nil(t, c.syncWorkload(Deployment, "web", "web"))
+	assert.Equal(t, 0, len(storedAlerts(t, c)))
+}
+
+func TestWorkloadAlertNotOwnedLeftAlone(t *testing.T) {
+	alert := &v12.AppOpticsAlert{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "deployment-web-memory"},
+		Spec:       v12.TokenAndDataSpec{Data: "name: Hand made\n"},
+	}
+	c := newTestController(config.Default(), newTestDeployment(map[string]string{BaselineAlertsAnnotation: "true"}), alert)
+
+	assert.Nil(t, c.syncWorkload(Deployment, "web", "web"))
+	alerts := storedAlerts(t, c)
+	assert.Equal(t, len(appoptics.BaselineAlertSuffixes()), len(alerts))
+	assert.Equal(t, alert.Spec, alerts["deployment-web-memory"].Spec)
+	assert.Contains(t, testEvents(c), "Warning ErrGenerate AppOpticsAlert deployment-web-memory exists and is not owned by this Deployment")
+
+	cacheGenerated(t, c)
+	setTestWorkload(c, newTestDeployment(nil))
+	assert.Nil(t, c.syncWorkload(Deployment, "web", "web"))
+	assert.Equal(t, []string{"deployment-web-memory"}, alertNames(storedAlerts(t, c)))
+}
+
+func alertNames(alerts map[string]v12.AppOpticsAlert) []string {
+	var names []string
+	for name := range alerts {
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestWorkloadAlertsMissingPolicyUseDefaults(t *testing.T) {
+	c := newTestController(config.Default(), newTestDeployment(map[string]string{AlertPolicyAnnotation: "strict"}))
+
+	assert.Nil(t, c.syncWorkload(Deployment, "web", "web"))
+	assertBaselineAlerts(t, c, appoptics.DefaultBaselineThresholds, "", nil)
+	assert.Equal(t, []string{"Warning ErrGenerate AppOpticsAlertPolicy strict not found, using the default thresholds"}, testEvents(c))
+}
+
+func TestWorkloadAlertsAnnotationsOverridePolicy(t *testing.T) {
+	restarts, memory := 5, 80
+	serviceRefs := []v12.ObjectReference{{Namespace: "monitoring", Name: "ops"}}
+	policy := &v12.AppOpticsAlertPolicy{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "strict"},
+		Spec:       v12.AlertPolicySpec{Restarts: &restarts, MemoryPercent: &memory, Secret: "ops", ServiceRefs: serviceRefs},
+	}
+	deployment := newTestDeployment(map[string]string{AlertPolicyAnnotation: "strict", MemoryAnnotation: "70", SecretAnnotation: "team"})
+	c := newTestController(config.Default(), deployment, policy)
+
+	assert.Nil(t, c.syncWorkload(Deployment, "web", "web"))
+	thresholds := appoptics.DefaultBaselineThresholds
+	thresholds.Restarts = 5
+	thresholds.MemoryPercent = 70
+	assertBaselineAlerts(t, c, thresholds, "ops", serviceRefs)
+	assert.Equal(t, 0, len(testEvents(c)))
+}
+
+func TestPolicyChangeEnqueuesItsWorkloads(t *testing.T) {
+	policy := &v12.AppOpticsAlertPolicy{ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "strict"}}
+	other := newTestDeployment(map[string]string{AlertPolicyAnnotation: "relaxed"})
+	other.Name = "api"
+	statefulSet := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "db", Annotations: map[string]string{AlertPolicyAnnotation: "strict"}}}
+	c := newTestController(config.Default(), newTestDeployment(map[string]string{AlertPolicyAnnotation: "strict"}), other, statefulSet, policy)
+
+	c.enqueuePolicyWorkloads(policy)
+	assert.ElementsMatch(t, []string{"web/Deployment/web", "web/StatefulSet/db"}, queuedKeys(c))
+
+	// Deleting the policy puts its workloads back on the default thresholds
+	for _, handler := range c.informers[testInformerIndex(policy)].handlers {
+		handler.OnDelete(policy)
+	}
+	assert.ElementsMatch(t, []string{"web/Deployment/web", "web/StatefulSet/db"}, queuedKeys(c))
+}
@@ -0,0 +1,127 @@
+// Package schedule parses the cron expressions maintenance windows open on.
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed standard five field cron expression: minute, hour, day of month, month and
+// day of week. Each field is a bitset of the values it matches.
+type Schedule struct {
+	minute, hour, dayOfMonth, month, dayOfWeek uint64
+	// Like cron, when both day fields are restricted a time matches if either of them does
+	dayOfMonthStar, dayOfWeekStar bool
+}
+
+type bounds struct {
+	min, max int
+}
+
+var (
+	minuteBounds     = bounds{0, 59}
+	hourBounds       = bounds{0, 23}
+	dayOfMonthBounds = bounds{1, 31}
+	monthBounds      = bounds{1, 12}
+	// Sunday is both 0 and 7
+	dayOfWeekBounds = bounds{0, 7}
+)
+
+// Parse parses a cron expression such as "30 2 * * 1-5". Fields may be *, a value, a range, a
+// comma separated list of those and have a /step.
+func Parse(spec string) (*Schedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("schedule %q must have 5 fields, minute hour day-of-month month day-of-week", spec)
+	}
+	var s Schedule
+	var err error
+	if s.minute, err = parseField(fields[0], minuteBounds); err != nil {
+		return nil, err
+	}
+	if s.hour, err = parseField(fields[1], hourBounds); err != nil {
+		return nil, err
+	}
+	if s.dayOfMonth, err = parseField(fields[2], dayOfMonthBounds); err != nil {
+		return nil, err
+	}
+	if s.month, err = parseField(fields[3], monthBounds); err != nil {
+		return nil, err
+	}
+	if s.dayOfWeek, err = parseField(fields[4], dayOfWeekBounds); err != nil {
+		return nil, err
+	}
+	if s.dayOfWeek&(1<<7) != 0 {
+		s.dayOfWeek |= 1
+	}
+	s.dayOfMonthStar = strings.HasPrefix(fields[2], "*")
+	s.dayOfWeekStar = strings.HasPrefix(fields[4], "*")
+	return &s, nil
+}
+
+func parseField(field string, b bounds) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i != -1 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			part = part[:i]
+		}
+
+		low, high := b.min, b.max
+		if part != "*" {
+			var err error
+			bounds := strings.SplitN(part, "-", 2)
+			if low, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value in %q", field)
+			}
+			high = low
+			if len(bounds) == 2 {
+				if high, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid value in %q", field)
+				}
+			} else if step != 1 {
+				// "5/15" means from 5 to the end of the range every 15
+				high = b.max
+			}
+		}
+		if low < b.min || high > b.max || low > high {
+			return 0, fmt.Errorf("%q is out of range %d-%d", part, b.min, b.max)
+		}
+		for v := low; v <= high; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// Matches reports whether the schedule fires in the minute of t
+func (s *Schedule) Matches(t time.Time) bool {
+	if s.minute&(1<<uint(t.Minute())) == 0 || s.hour&(1<<uint(t.Hour())) == 0 || s.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	dayOfMonth := s.dayOfMonth&(1<<uint(t.Day())) != 0
+	dayOfWeek := s.dayOfWeek&(1<<uint(t.Weekday())) != 0
+	if s.dayOfMonthStar || s.dayOfWeekStar {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
+
+// LastFired returns the last minute the schedule fired in the period of the given length ending at
+// t. It returns false if the schedule did not fire in that period.
+func (s *Schedule) LastFired(t time.Time, within time.Duration) (time.Time, bool) {
+	start := t.Add(-within)
+	for at := t.Truncate(time.Minute); at.After(start); at = at.Add(-time.Minute) {
+		if s.Matches(at) {
+			return at, true
+		}
+	}
+	return time.Time{}, false
+}
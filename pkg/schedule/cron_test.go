@@ -0,0 +1,63 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "a * * * *", "5-1 * * * *"} {
+		_, err := Parse(spec)
+		assert.NotEqual(t, nil, err, spec)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	// Monday 2 September 2019
+	monday := time.Date(2019, time.September, 2, 2, 30, 0, 0, time.UTC)
+
+	cases := map[string]bool{
+		"* * * * *":       true,
+		"30 2 * * *":      true,
+		"31 2 * * *":      false,
+		"*/15 * * * *":    true,
+		"*/20 * * * *":    false,
+		"0-30/10 2 * * *": true,
+		"30 2 * * 1-5":    true,
+		"30 2 * * 0,6":    false,
+		"30 2 * 9 *":      true,
+		"30 2 * 10 *":     false,
+		// Both day fields restricted, the day of month matches
+		"30 2 2 * 0": true,
+		// Both day fields restricted, neither matches
+		"30 2 3 * 0": false,
+	}
+	for spec, expected := range cases {
+		s, err := Parse(spec)
+		assert.Equal(t, nil, err, spec)
+		assert.Equal(t, expected, s.Matches(monday), spec)
+	}
+}
+
+func TestSundayIsZeroAndSeven(t *testing.T) {
+	sunday := time.Date(2019, time.September, 1, 0, 0, 0, 0, time.UTC)
+	s, err := Parse("0 0 * * 7")
+	assert.Equal(t, nil, err)
+	assert.True(t, s.Matches(sunday))
+}
+
+func TestLastFired(t *testing.T) {
+	s, err := Parse("0 2 * * *")
+	assert.Equal(t, nil, err)
+
+	now := time.Date(2019, time.September, 2, 2, 59, 30, 0, time.UTC)
+	fired, ok := s.LastFired(now, time.Hour)
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2019, time.September, 2, 2, 0, 0, 0, time.UTC), fired)
+
+	// The period is open at its start, an hour after 02:00 the window has closed
+	_, ok = s.LastFired(now.Add(time.Minute), time.Hour)
+	assert.False(t, ok)
+}